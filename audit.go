@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AUDIT_ACTION_ACCEPT_INGEST      = "accept_ingest"
+	AUDIT_ACTION_ACTIVATE_INGEST    = "activate_ingest" // accepted by token or over the phone
+	AUDIT_ACTION_SUBMIT_ANSWER      = "submit_answer"
+	AUDIT_ACTION_INITIAL_SUBMISSION = "initial_submission"
+	AUDIT_ACTION_EXTEND_DEADLINE    = "extend_deadline"
+
+	AUDIT_OUTCOME_SUCCESS = "success"
+	AUDIT_OUTCOME_FAILURE = "failure"
+
+	AUDIT_DEFAULT_LIMIT = 50
+	AUDIT_MAX_LIMIT     = 500
+)
+
+// Request fields left out of the payload hash, so the audit log cannot be
+// used to brute-force them offline
+var auditRedactedFields = []string{"password", "secret"}
+
+// AuditContext identifies who performed an action and from where. Actor is
+// an operator username, or a label such as "accept-token" for actions that
+// do not come from the dashboard.
+type AuditContext struct {
+	Actor       string
+	ClientIP    string
+	PayloadHash string
+}
+
+// AuditTargets are the records an audited action touched.
+type AuditTargets struct {
+	IngestID  *uint
+	SessionID *uint
+	AttemptID *uint
+}
+
+// AuditFilter selects audit events. Zero values match everything; Before
+// is an event ID cursor for paging backwards.
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	Outcome   string
+	IngestID  uint
+	SessionID uint
+	From      time.Time
+	To        time.Time
+	Before    uint
+	Limit     int
+}
+
+type AuditPage struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor *uint        `json:"nextCursor"`
+}
+
+// newAuditContext describes the request behind an action. The body is read
+// and restored so the handler can still bind it.
+func newAuditContext(c *gin.Context, actor string) AuditContext {
+	payload := map[string]any{}
+
+	if c.Request.Body != nil {
+		body, err := io.ReadAll(c.Request.Body)
+		if err == nil {
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+			json.Unmarshal(body, &payload)
+		}
+	}
+
+	for key, values := range c.Request.URL.Query() {
+		if len(values) > 0 {
+			payload[key] = values[0]
+		}
+	}
+
+	for _, field := range auditRedactedFields {
+		delete(payload, field)
+	}
+
+	// Map keys marshal in sorted order, so equal payloads hash equally
+	canonical, _ := json.Marshal(payload)
+	hash := sha256.Sum256(canonical)
+
+	return AuditContext{
+		Actor:       actor,
+		ClientIP:    c.ClientIP(),
+		PayloadHash: hex.EncodeToString(hash[:]),
+	}
+}
+
+// recordAuditEvent appends an event for an action that finished with err.
+// Failing to write the event is logged but does not undo the action.
+func recordAuditEvent(audit AuditContext, action string, targets AuditTargets, err error) {
+	event := AuditEvent{
+		Actor:       audit.Actor,
+		Action:      action,
+		IngestID:    targets.IngestID,
+		SessionID:   targets.SessionID,
+		AttemptID:   targets.AttemptID,
+		ClientIP:    audit.ClientIP,
+		PayloadHash: audit.PayloadHash,
+		Outcome:     AUDIT_OUTCOME_SUCCESS,
+	}
+
+	if err != nil {
+		event.Outcome = AUDIT_OUTCOME_FAILURE
+		event.Error = err.Error()
+	}
+
+	if err := DB.Create(&event).Error; err != nil {
+		log.Printf("Failed to record audit event %s by %s: %v", action, audit.Actor, err)
+	}
+}
+
+// parseAuditFilter reads an AuditFilter from the query string. Times are
+// RFC 3339 and IDs are decimal.
+func parseAuditFilter(c *gin.Context) (AuditFilter, error) {
+	filter := AuditFilter{
+		Actor:   c.Query("actor"),
+		Action:  c.Query("action"),
+		Outcome: c.Query("outcome"),
+	}
+
+	for key, dst := range map[string]*uint{
+		"ingestId":  &filter.IngestID,
+		"sessionId": &filter.SessionID,
+		"before":    &filter.Before,
+	} {
+		if value := c.Query(key); value != "" {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			*dst = uint(n)
+		}
+	}
+
+	for key, dst := range map[string]*time.Time{
+		"from": &filter.From,
+		"to":   &filter.To,
+	} {
+		if value := c.Query(key); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			*dst = t
+		}
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit %q", value)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+// ListAuditEvents returns matching events, newest first.
+func ListAuditEvents(filter AuditFilter) (*AuditPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = AUDIT_DEFAULT_LIMIT
+	}
+	if filter.Limit > AUDIT_MAX_LIMIT {
+		filter.Limit = AUDIT_MAX_LIMIT
+	}
+
+	query := DB.Model(&AuditEvent{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if filter.IngestID != 0 {
+		query = query.Where("ingest_id = ?", filter.IngestID)
+	}
+	if filter.SessionID != 0 {
+		query = query.Where("session_id = ?", filter.SessionID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Before != 0 {
+		query = query.Where("id < ?", filter.Before)
+	}
+
+	// Fetch one extra row to know whether there is another page
+	var events []AuditEvent
+	if err := query.Order("id DESC").Limit(filter.Limit + 1).Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	page := AuditPage{Events: events}
+	if len(events) > filter.Limit {
+		page.Events = events[:filter.Limit]
+		cursor := page.Events[filter.Limit-1].ID
+		page.NextCursor = &cursor
+	}
+
+	return &page, nil
+}
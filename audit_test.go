@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAuditRecordsPrivilegedActions(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{})
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", grader.QuestionURL(0))).expect(t, 200, "ingest_created")
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, "wrong")).expect(t, 401, "invalid_password")
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword)).
+		expect(t, 200, "ingest_accepted_and_quiz_started")
+
+	session := mustFindSession(t, 1)
+	answer(t, r, grader, session, 0, 99).expect(t, 200, "answer_submitted")
+
+	var page AuditPage
+	doRequest(t, r, "GET", "/audit", nil).expect(t, 200, "audit_events_listed").decode(t, &page)
+
+	if len(page.Events) != 3 || page.NextCursor != nil {
+		t.Fatalf("got %d events (cursor %v), want 3", len(page.Events), page.NextCursor)
+	}
+
+	submit, accepted, rejected := page.Events[0], page.Events[1], page.Events[2]
+	if rejected.Action != AUDIT_ACTION_ACCEPT_INGEST || rejected.Outcome != AUDIT_OUTCOME_FAILURE || rejected.Error == "" {
+		t.Errorf("unexpected rejected accept event %+v", rejected)
+	}
+	if accepted.Action != AUDIT_ACTION_ACCEPT_INGEST || accepted.Outcome != AUDIT_OUTCOME_SUCCESS ||
+		accepted.Actor != testOperator || accepted.IngestID == nil || *accepted.IngestID != 1 || accepted.ClientIP == "" {
+		t.Errorf("unexpected accept event %+v", accepted)
+	}
+	if submit.Action != AUDIT_ACTION_SUBMIT_ANSWER || submit.Outcome != AUDIT_OUTCOME_SUCCESS ||
+		submit.SessionID == nil || *submit.SessionID != session.ID || submit.AttemptID == nil {
+		t.Errorf("unexpected submit event %+v", submit)
+	}
+
+	// The wrong and right passwords are redacted before hashing, so both
+	// accepts hash the same payload
+	if accepted.PayloadHash == "" || accepted.PayloadHash != rejected.PayloadHash {
+		t.Errorf("payload hashes %q and %q should match", accepted.PayloadHash, rejected.PayloadHash)
+	}
+}
+
+func TestAuditFiltersAndPagination(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+
+	for i := 1; i <= 5; i++ {
+		doRequest(t, r, "POST", "/ingest", ingestBody(fmt.Sprintf("%d@example.com", i), "https://example.com/q1")).
+			expect(t, 200, "ingest_created")
+	}
+	for i := 1; i <= 5; i++ {
+		password := testAcceptPassword
+		if i%2 == 0 {
+			password = "wrong"
+		}
+		doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(uint(i), password))
+	}
+
+	var page AuditPage
+	doRequest(t, r, "GET", "/audit?outcome=failure", nil).expect(t, 200, "audit_events_listed").decode(t, &page)
+	if len(page.Events) != 2 {
+		t.Errorf("got %d failures, want 2", len(page.Events))
+	}
+
+	doRequest(t, r, "GET", "/audit?ingestId=3", nil).expect(t, 200, "audit_events_listed").decode(t, &page)
+	if len(page.Events) != 1 || *page.Events[0].IngestID != 3 {
+		t.Errorf("unexpected events for ingest 3 %+v", page.Events)
+	}
+
+	var ids []uint
+	path := "/audit?limit=2"
+	for pages := 0; ; pages++ {
+		page = AuditPage{}
+		doRequest(t, r, "GET", path, nil).expect(t, 200, "audit_events_listed").decode(t, &page)
+		for _, event := range page.Events {
+			ids = append(ids, event.ID)
+		}
+		if page.NextCursor == nil {
+			break
+		}
+		if pages > 5 {
+			t.Fatal("pagination did not terminate")
+		}
+		path = fmt.Sprintf("/audit?limit=2&before=%d", *page.NextCursor)
+	}
+	if len(ids) != 5 || ids[0] != 5 || ids[4] != 1 {
+		t.Errorf("paged through %v, want 5 to 1", ids)
+	}
+
+	doRequest(t, r, "GET", "/audit?from=yesterday", nil).expect(t, 400, "invalid_audit_filter")
+	doRequest(t, r, "GET", "/audit?limit=0", nil).expect(t, 400, "invalid_audit_filter")
+}
+
+func TestAuditRequiresAdmin(t *testing.T) {
+	r := newTestRouter(t)
+
+	if _, err := SaveOperator("acceptor", testOperatorPass, OperatorRoleAcceptor); err != nil {
+		t.Fatal(err)
+	}
+
+	doRequest(t, r, "GET", "/audit", nil).expect(t, 401, "unauthenticated")
+	doRequest(t, r, "GET", "/audit", nil, login(t, r, "acceptor", testOperatorPass)...).
+		expect(t, 403, "permission_denied")
+}
+
+func TestAuditEventsAreAppendOnly(t *testing.T) {
+	newTestRouter(t)
+
+	recordAuditEvent(AuditContext{Actor: "system"}, AUDIT_ACTION_INITIAL_SUBMISSION, AuditTargets{}, nil)
+
+	if err := DB.Model(&AuditEvent{}).Where("id = ?", 1).Update("actor", "someone-else").Error; err == nil ||
+		!strings.Contains(err.Error(), "append-only") {
+		t.Errorf("update: got %v, want append-only error", err)
+	}
+	if err := DB.Delete(&AuditEvent{}, 1).Error; err == nil || !strings.Contains(err.Error(), "append-only") {
+		t.Errorf("delete: got %v, want append-only error", err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	OPERATOR_SESSION_COOKIE = "sdt_session"
+	CSRF_HEADER             = "X-CSRF-Token"
+
+	MIN_OPERATOR_PASSWORD_LENGTH = 8
+)
+
+type OperatorRole string
+
+const (
+	OperatorRoleViewer   OperatorRole = "viewer"
+	OperatorRoleAcceptor OperatorRole = "acceptor"
+	OperatorRoleSolver   OperatorRole = "solver"
+	OperatorRoleAdmin    OperatorRole = "admin"
+)
+
+type Permission string
+
+const (
+	PermissionView   Permission = "view"
+	PermissionAccept Permission = "accept" // accept ingests
+	PermissionAnswer Permission = "answer" // submit answers and initial submissions
+	PermissionAdmin  Permission = "admin"
+)
+
+var rolePermissions = map[OperatorRole][]Permission{
+	OperatorRoleViewer:   {PermissionView},
+	OperatorRoleAcceptor: {PermissionView, PermissionAccept},
+	OperatorRoleSolver:   {PermissionView, PermissionAnswer},
+	OperatorRoleAdmin:    {PermissionView, PermissionAccept, PermissionAnswer, PermissionAdmin},
+}
+
+// Can reports whether the operator's role grants a permission.
+func (o *Operator) Can(permission Permission) bool {
+	for _, p := range rolePermissions[o.Role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func validOperatorRole(role OperatorRole) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrOperatorNotFound   = errors.New("operator not found")
+	ErrSessionNotFound    = errors.New("session not found or expired")
+)
+
+// Compared against when the username is unknown, so a failed login takes
+// as long whether or not the account exists
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+// SaveOperator creates an operator or resets an existing one's password.
+// An empty role keeps the current one, or makes a new operator a viewer.
+func SaveOperator(username, password string, role OperatorRole) (*Operator, error) {
+	if username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	if role != "" && !validOperatorRole(role) {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	if len(password) < MIN_OPERATOR_PASSWORD_LENGTH {
+		return nil, fmt.Errorf("password must be at least %d characters", MIN_OPERATOR_PASSWORD_LENGTH)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	var operator Operator
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("username = ?", username).Limit(1).Find(&operator).Error; err != nil {
+			return err
+		}
+
+		operator.Username = username
+		operator.PasswordHash = string(hash)
+		if role != "" {
+			operator.Role = role
+		} else if operator.Role == "" {
+			operator.Role = OperatorRoleViewer
+		}
+		if err := tx.Save(&operator).Error; err != nil {
+			return err
+		}
+
+		// A new password signs out every existing session
+		return tx.Where("operator_id = ?", operator.ID).Delete(&OperatorSession{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &operator, nil
+}
+
+func ListOperators() ([]Operator, error) {
+	var operators []Operator
+	err := DB.Order("username ASC").Find(&operators).Error
+	return operators, err
+}
+
+// SetOperatorRole changes an operator's role. It applies to their existing
+// sessions immediately.
+func SetOperatorRole(username string, role OperatorRole) error {
+	if !validOperatorRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	result := DB.Model(&Operator{}).Where("username = ?", username).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOperatorNotFound
+	}
+	return nil
+}
+
+// DeleteOperator removes an operator and their sessions.
+func DeleteOperator(username string) error {
+	result := DB.Where("username = ?", username).Delete(&Operator{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOperatorNotFound
+	}
+	return nil
+}
+
+// Login checks an operator's password and opens a session. The returned
+// token goes in the session cookie and is never stored.
+func Login(username, password string) (*Operator, *OperatorSession, string, error) {
+	var operator Operator
+	err := DB.Where("username = ?", username).First(&operator).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, nil, "", ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(operator.PasswordHash), []byte(password)); err != nil {
+		return nil, nil, "", ErrInvalidCredentials
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	session := OperatorSession{
+		OperatorID: operator.ID,
+		TokenHash:  hashSessionToken(token),
+		CSRFToken:  csrfToken,
+		ExpiresAt:  time.Now().Add(AppConfig.OperatorSessionTTL),
+	}
+	if err := DB.Create(&session).Error; err != nil {
+		return nil, nil, "", fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return &operator, &session, token, nil
+}
+
+// LookupOperatorSession resolves a session cookie to its operator.
+func LookupOperatorSession(token string) (*Operator, *OperatorSession, error) {
+	if token == "" {
+		return nil, nil, ErrSessionNotFound
+	}
+
+	var session OperatorSession
+	err := DB.Where("token_hash = ? AND expires_at > ?", hashSessionToken(token), time.Now()).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var operator Operator
+	if err := DB.First(&operator, session.OperatorID).Error; err != nil {
+		return nil, nil, ErrSessionNotFound
+	}
+
+	return &operator, &session, nil
+}
+
+func Logout(sessionID uint) error {
+	return DB.Delete(&OperatorSession{}, sessionID).Error
+}
+
+func purgeExpiredOperatorSessions(now time.Time) error {
+	return DB.Where("expires_at <= ?", now).Delete(&OperatorSession{}).Error
+}
+
+// secretsEqual compares a guess with a shared secret in constant time. Both
+// are hashed first so the comparison does not leak the secret's length.
+func secretsEqual(given, expected string) bool {
+	givenHash := sha256.Sum256([]byte(given))
+	expectedHash := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(givenHash[:], expectedHash[:]) == 1
+}
+
+func hashSessionToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboardRoutesRequireLogin(t *testing.T) {
+	r := newTestRouter(t)
+
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/ingest"},
+		{"GET", "/ingest/1/history"},
+		{"POST", "/ingest/notification-accept"},
+		{"GET", "/quiz/sessions"},
+		{"GET", "/quiz/pending"},
+		{"GET", "/quiz/sessions/1/attempts"},
+		{"POST", "/quiz/sessions/1/answer"},
+		{"POST", "/submit-initial"},
+		{"GET", "/auth/me"},
+	} {
+		res := doRequest(t, r, route.method, route.path, map[string]any{})
+		if res.Code != http.StatusUnauthorized || res.Message != "unauthenticated" {
+			t.Errorf("%s %s: got %d %q, want 401 unauthenticated", route.method, route.path, res.Code, res.Message)
+		}
+	}
+
+	doRequest(t, r, "GET", "/quiz/sessions", nil, "Cookie", OPERATOR_SESSION_COOKIE+"=forged").
+		expect(t, 401, "unauthenticated")
+}
+
+func TestLogin(t *testing.T) {
+	r := newTestRouter(t)
+
+	doRequest(t, r, "POST", "/auth/login", map[string]any{"username": testOperator, "password": "wrong-password"}).
+		expect(t, 401, "invalid_credentials")
+	doRequest(t, r, "POST", "/auth/login", map[string]any{"username": "nobody", "password": testOperatorPass}).
+		expect(t, 401, "invalid_credentials")
+
+	req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "operator", "password": "operator-pass"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != OPERATOR_SESSION_COOKIE || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("unexpected session cookie %+v", cookies)
+	}
+
+	// Only a hash of the cookie value is stored
+	var session OperatorSession
+	DB.First(&session)
+	if session.TokenHash == cookies[0].Value || session.TokenHash != hashSessionToken(cookies[0].Value) {
+		t.Errorf("session token is not stored hashed")
+	}
+
+	var info OperatorInfo
+	auth := login(t, r, testOperator, testOperatorPass)
+	doRequest(t, r, "GET", "/auth/me", nil, auth...).expect(t, 200, "operator_retrieved").decode(t, &info)
+	if info.Username != testOperator || info.CSRFToken == "" {
+		t.Errorf("unexpected operator info %+v", info)
+	}
+}
+
+func TestStateChangingRoutesRequireCSRF(t *testing.T) {
+	r := newTestRouter(t)
+	auth := login(t, r, testOperator, testOperatorPass)
+	cookieOnly := auth[:2]
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", "https://example.com/q1")).expect(t, 200, "ingest_created")
+
+	// Reads only need the cookie
+	doRequest(t, r, "GET", "/ingest", nil, cookieOnly...).expect(t, 200, "ingests_listed")
+
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword), cookieOnly...).
+		expect(t, 403, "invalid_csrf_token")
+	doRequest(t, r, "POST", "/submit-initial", map[string]any{}, cookieOnly...).
+		expect(t, 403, "invalid_csrf_token")
+	doRequest(t, r, "POST", "/quiz/sessions/1/answer", map[string]any{}, "Cookie", auth[1], CSRF_HEADER, "guess").
+		expect(t, 403, "invalid_csrf_token")
+
+	if ingest := mustFindIngest(t, 1); ingest.Status != IngestStatusPending {
+		t.Fatalf("request without CSRF token moved ingest to %q", ingest.Status)
+	}
+
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword), auth...).
+		expect(t, 200, "ingest_accepted_and_quiz_started")
+}
+
+func TestLogout(t *testing.T) {
+	r := newTestRouter(t)
+	auth := login(t, r, testOperator, testOperatorPass)
+
+	doRequest(t, r, "POST", "/auth/logout", nil, auth[:2]...).expect(t, 403, "invalid_csrf_token")
+	doRequest(t, r, "POST", "/auth/logout", nil, auth...).expect(t, 200, "logged_out")
+	doRequest(t, r, "GET", "/quiz/sessions", nil, auth...).expect(t, 401, "unauthenticated")
+}
+
+func TestPasswordChangeEndsSessions(t *testing.T) {
+	r := newTestRouter(t)
+	auth := login(t, r, testOperator, testOperatorPass)
+
+	if _, err := SaveOperator(testOperator, "a-new-password", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	doRequest(t, r, "GET", "/quiz/sessions", nil, auth...).expect(t, 401, "unauthenticated")
+	login(t, r, testOperator, "a-new-password")
+}
+
+func TestRolePermissions(t *testing.T) {
+	r := newTestRouter(t)
+	grader := newTestGrader(t, MockGraderOptions{})
+
+	for _, role := range []OperatorRole{OperatorRoleViewer, OperatorRoleAcceptor, OperatorRoleSolver} {
+		if _, err := SaveOperator(string(role), testOperatorPass, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	viewer := login(t, r, "viewer", testOperatorPass)
+	acceptor := login(t, r, "acceptor", testOperatorPass)
+	solver := login(t, r, "solver", testOperatorPass)
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", grader.QuestionURL(0))).expect(t, 200, "ingest_created")
+	accept := acceptBody(1, testAcceptPassword)
+
+	doRequest(t, r, "GET", "/ingest", nil, viewer...).expect(t, 200, "ingests_listed")
+	doRequest(t, r, "POST", "/ingest/notification-accept", accept, viewer...).expect(t, 403, "permission_denied")
+	doRequest(t, r, "POST", "/ingest/notification-accept", accept, solver...).expect(t, 403, "permission_denied")
+	doRequest(t, r, "POST", "/ingest/notification-accept", accept, acceptor...).expect(t, 200, "ingest_accepted_and_quiz_started")
+
+	if ingest := mustFindIngest(t, 1); ingest.AcceptedBy != "acceptor" {
+		t.Errorf("acceptedBy = %q, want acceptor", ingest.AcceptedBy)
+	}
+
+	body := map[string]any{
+		"password":  testAttemptPass,
+		"submitUrl": grader.SubmitURL(),
+		"answer":    13,
+	}
+	doRequest(t, r, "POST", "/quiz/sessions/1/answer", body, viewer...).expect(t, 403, "permission_denied")
+	doRequest(t, r, "POST", "/quiz/sessions/1/answer", body, acceptor...).expect(t, 403, "permission_denied")
+	doRequest(t, r, "POST", "/submit-initial", map[string]any{}, acceptor...).expect(t, 403, "permission_denied")
+	doRequest(t, r, "POST", "/quiz/sessions/1/answer", body, solver...).expect(t, 200, "answer_submitted")
+
+	attempts, err := GetQuizAttempts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts[0].SubmittedBy != "solver" {
+		t.Errorf("submittedBy = %q, want solver", attempts[0].SubmittedBy)
+	}
+
+	// Role changes apply to existing sessions
+	if err := SetOperatorRole("viewer", OperatorRoleAcceptor); err != nil {
+		t.Fatal(err)
+	}
+	doRequest(t, r, "POST", "/ingest/notification-accept", accept, viewer...).expect(t, 409, "invalid_state_transition")
+}
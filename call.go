@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo"
+	"github.com/emiago/sipgo/sip"
+)
+
+const DEFAULT_ESCALATION_DELAY = 60 * time.Second
+const DEFAULT_CALL_DURATION = 45 * time.Second
+
+var errCallAccepted = errors.New("ingest accepted over dtmf")
+
+// SIPCaller places outbound calls to the on-call number, plays the beep
+// pattern and listens for DTMF "1" as the accept signal.
+type SIPCaller struct {
+	dg        *diago.Diago
+	recipient sip.Uri
+	username  string
+	password  string
+	duration  time.Duration
+}
+
+type SIPCallerOptions struct {
+	Target   string // e.g. sip:oncall@pbx.example.com:5060
+	BindHost string
+	BindPort int
+	Username string
+	Password string
+	Duration time.Duration
+}
+
+var (
+	callerMu sync.Mutex
+	caller   *SIPCaller
+)
+
+// Tracks calls in progress, so tests can wait for them to finish
+var escalationCalls sync.WaitGroup
+
+// NewSIPCaller creates a caller bound to a local UDP transport. The beep
+// payload is A-law encoded, so PCMA is the only audio codec offered.
+func NewSIPCaller(ctx context.Context, opts SIPCallerOptions) (*SIPCaller, error) {
+	var recipient sip.Uri
+	if err := sip.ParseUri(opts.Target, &recipient); err != nil {
+		return nil, fmt.Errorf("invalid SIP target %q: %v", opts.Target, err)
+	}
+
+	ua, err := sipgo.NewUA(sipgo.WithUserAgent("project-2-sdt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SIP user agent: %v", err)
+	}
+
+	bindHost := opts.BindHost
+	if bindHost == "" {
+		bindHost = "0.0.0.0"
+	}
+
+	dg := diago.NewDiago(ua,
+		diago.WithTransport(diago.Transport{
+			Transport: "udp",
+			BindHost:  bindHost,
+			BindPort:  opts.BindPort,
+		}),
+		diago.WithMediaConfig(diago.MediaConfig{
+			Codecs: []media.Codec{media.CodecAudioAlaw, media.CodecTelephoneEvent8000},
+		}),
+	)
+
+	// Serve so in-dialog requests (BYE, INFO) from the callee reach us.
+	// Inbound calls are not expected and are left unanswered.
+	if err := dg.ServeBackground(ctx, func(d *diago.DialogServerSession) {}); err != nil {
+		return nil, fmt.Errorf("failed to start SIP transport: %v", err)
+	}
+
+	duration := opts.Duration
+	if duration <= 0 {
+		duration = DEFAULT_CALL_DURATION
+	}
+
+	return &SIPCaller{
+		dg:        dg,
+		recipient: recipient,
+		username:  opts.Username,
+		password:  opts.Password,
+		duration:  duration,
+	}, nil
+}
+
+// Call rings the on-call number and reports whether the callee pressed "1"
+// before the call duration elapsed or the context was cancelled.
+func (c *SIPCaller) Call(ctx context.Context, ingest Ingests) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.duration)
+	defer cancel()
+
+	dialog, err := c.dg.Invite(ctx, c.recipient, diago.InviteOptions{
+		Username: c.username,
+		Password: c.password,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to place call for ingest %d: %v", ingest.ID, err)
+	}
+	defer dialog.Close()
+
+	writer, err := dialog.AudioWriter()
+	if err != nil {
+		return false, fmt.Errorf("failed to open audio writer: %v", err)
+	}
+
+	go playBeepPattern(ctx, writer)
+
+	// Closing the dialog unblocks the DTMF reader once time is up or the
+	// callee hangs up.
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-dialog.Context().Done():
+		}
+		dialog.Close()
+	}()
+
+	err = dialog.AudioReaderDTMF().Listen(func(digit rune) error {
+		if digit == '1' {
+			return errCallAccepted
+		}
+		return nil
+	}, 0)
+
+	hangupCtx, hangupCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer hangupCancel()
+	dialog.Hangup(hangupCtx)
+
+	if errors.Is(err, errCallAccepted) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// playBeepPattern writes one second of beep frames followed by one second of
+// silence until the context is done. Frames are paced at the 20ms G.711 rate.
+func playBeepPattern(ctx context.Context, w io.Writer) {
+	beep := generateBeepPayload()
+	silence := make([]byte, len(beep))
+	for i := range silence {
+		silence[i] = 0xD5 // A-law zero
+	}
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	for frame := 0; ; frame++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		payload := beep
+		if (frame/50)%2 == 1 {
+			payload = silence
+		}
+
+		if _, err := w.Write(payload); err != nil {
+			return
+		}
+	}
+}
+
+// EscalateIngest places a voice call for an ingest that has stayed Pending
+// longer than SIP_ESCALATION_DELAY. The notification ledger ensures each
+// ingest is called at most once, even across restarts.
+func EscalateIngest(ingest Ingests) {
+	if AppConfig.SIPOncallURI == "" {
+		return
+	}
+
+	if time.Since(ingest.CreatedAt) < AppConfig.SIPEscalationDelay || time.Now().After(ingest.Deadline) {
+		return
+	}
+
+	entry, due, err := notificationDue(ingest.ID, NOTIFICATION_CHANNEL_SIP, 1)
+	if err != nil {
+		log.Printf("Failed to read notification ledger for ingest ID %d: %v", ingest.ID, err)
+		return
+	}
+
+	if !due {
+		return
+	}
+
+	c, err := getSIPCaller()
+	if err != nil {
+		log.Printf("Failed to set up SIP caller for ingest ID %d: %v", ingest.ID, err)
+		// No call was placed, so leave the attempt for the next tick
+		markNotificationFailed(entry, err)
+		return
+	}
+
+	if err := recordNotification(entry, nil); err != nil {
+		log.Printf("Failed to update notification ledger for ingest ID %d: %v", ingest.ID, err)
+		return
+	}
+
+	escalationCalls.Add(1)
+	go func() {
+		defer escalationCalls.Done()
+
+		ctx, cancel := context.WithDeadline(context.Background(), ingest.Deadline)
+		defer cancel()
+
+		log.Printf("Escalating ingest ID %d to %s", ingest.ID, AppConfig.SIPOncallURI)
+
+		accepted, err := c.Call(ctx, ingest)
+		if err != nil {
+			log.Printf("Voice escalation failed for ingest ID %d: %v", ingest.ID, err)
+			markNotificationFailed(entry, err)
+			return
+		}
+
+		if !accepted {
+			log.Printf("Voice escalation for ingest ID %d was not accepted", ingest.ID)
+			return
+		}
+
+		// The ingest may have been accepted from the UI while the phone rang
+		err = ActivateIngest(ingest.ID, AuditContext{Actor: "dtmf:" + AppConfig.SIPOncallURI})
+		if errors.Is(err, ErrIngestNotPending) {
+			return
+		}
+
+		if err != nil {
+			log.Printf("Failed to accept ingest ID %d over DTMF: %v", ingest.ID, err)
+			return
+		}
+
+		log.Printf("Ingest ID %d accepted over DTMF", ingest.ID)
+	}()
+}
+
+func getSIPCaller() (*SIPCaller, error) {
+	callerMu.Lock()
+	defer callerMu.Unlock()
+
+	if caller != nil {
+		return caller, nil
+	}
+
+	c, err := NewSIPCaller(context.Background(), SIPCallerOptions{
+		Target:   AppConfig.SIPOncallURI,
+		BindHost: AppConfig.SIPBindHost,
+		BindPort: AppConfig.SIPBindPort,
+		Username: AppConfig.SIPUsername,
+		Password: AppConfig.SIPPassword,
+		Duration: AppConfig.SIPCallDuration,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	caller = c
+	return caller, nil
+}
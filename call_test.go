@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emiago/diago"
+	"github.com/emiago/diago/media"
+	"github.com/emiago/sipgo"
+)
+
+// startSIPStandIn starts a local user agent that answers every call and
+// hands it to handle. It returns the SIP URI to call it on.
+func startSIPStandIn(t *testing.T, handle func(d *diago.DialogServerSession)) string {
+	t.Helper()
+
+	// diago only reports the port it bound through the Contact header, so
+	// pick a free one up front
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	ua, err := sipgo.NewUA(sipgo.WithUserAgent("oncall-stand-in"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls sync.WaitGroup
+	t.Cleanup(func() {
+		cancel()
+		calls.Wait()
+		ua.Close()
+	})
+
+	dg := diago.NewDiago(ua,
+		diago.WithTransport(diago.Transport{Transport: "udp", BindHost: "127.0.0.1", BindPort: port}),
+		diago.WithMediaConfig(diago.MediaConfig{
+			Codecs: []media.Codec{media.CodecAudioAlaw, media.CodecTelephoneEvent8000},
+		}),
+	)
+	err = dg.ServeBackground(ctx, func(d *diago.DialogServerSession) {
+		calls.Add(1)
+		defer calls.Done()
+
+		if err := d.Answer(); err != nil {
+			t.Errorf("stand-in failed to answer: %v", err)
+			return
+		}
+		handle(d)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fmt.Sprintf("sip:oncall@127.0.0.1:%d", port)
+}
+
+// sendDTMF presses digit on the stand-in once the caller is listening.
+func sendDTMF(t *testing.T, digit rune) func(d *diago.DialogServerSession) {
+	return func(d *diago.DialogServerSession) {
+		// Give the caller a moment to start listening
+		time.Sleep(200 * time.Millisecond)
+		// The caller hangs up as soon as it reads the digit
+		if err := d.AudioWriterDTMF().WriteDTMF(digit); err != nil && d.Context().Err() == nil {
+			t.Errorf("stand-in failed to send DTMF: %v", err)
+		}
+		<-d.Context().Done()
+	}
+}
+
+func hangUp(d *diago.DialogServerSession) {
+	time.Sleep(200 * time.Millisecond)
+	d.Hangup(d.Context())
+}
+
+// useSIPStandIn starts a stand-in with handle and points escalation calls
+// at it.
+func useSIPStandIn(t *testing.T, handle func(d *diago.DialogServerSession)) {
+	t.Helper()
+
+	AppConfig.SIPOncallURI = startSIPStandIn(t, handle)
+	AppConfig.SIPBindHost = "127.0.0.1"
+	AppConfig.SIPEscalationDelay = 0
+	AppConfig.SIPCallDuration = 5 * time.Second
+
+	// The caller is built once from the config, so each test gets its own
+	callerMu.Lock()
+	caller = nil
+	callerMu.Unlock()
+	// Runs before the database is closed
+	t.Cleanup(escalationCalls.Wait)
+}
+
+func newTestSIPCaller(t *testing.T, target string) *SIPCaller {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	c, err := NewSIPCaller(ctx, SIPCallerOptions{
+		Target:   target,
+		BindHost: "127.0.0.1",
+		Duration: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSIPCallerAcceptedOverDTMF(t *testing.T) {
+	c := newTestSIPCaller(t, startSIPStandIn(t, sendDTMF(t, '1')))
+
+	accepted, err := c.Call(context.Background(), Ingests{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !accepted {
+		t.Error("call was not accepted after DTMF 1")
+	}
+}
+
+func TestSIPCallerIgnoresOtherDigits(t *testing.T) {
+	c := newTestSIPCaller(t, startSIPStandIn(t, sendDTMF(t, '2')))
+	c.duration = time.Second
+
+	accepted, err := c.Call(context.Background(), Ingests{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted {
+		t.Error("call was accepted after DTMF 2")
+	}
+}
+
+func TestSIPCallerHangUpWithoutDTMF(t *testing.T) {
+	c := newTestSIPCaller(t, startSIPStandIn(t, hangUp))
+
+	start := time.Now()
+	accepted, err := c.Call(context.Background(), Ingests{ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted {
+		t.Error("call was accepted after a hang-up")
+	}
+	if elapsed := time.Since(start); elapsed >= c.duration {
+		t.Errorf("call ran for %v after the hang-up", elapsed)
+	}
+}
+
+func TestEscalateIngestAcceptedOverDTMF(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	useSIPStandIn(t, sendDTMF(t, '1'))
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", "https://example.com/q1")).expect(t, 200, "ingest_created")
+
+	EscalateIngest(mustFindIngest(t, 1))
+	escalationCalls.Wait()
+
+	if ingest := mustFindIngest(t, 1); ingest.Status != IngestStatusRunning {
+		t.Fatalf("ingest status = %q after DTMF 1, want %q", ingest.Status, IngestStatusRunning)
+	}
+	if session := mustFindSession(t, 1); session.IngestID != 1 || session.Status != QuizSessionStatusWaiting {
+		t.Errorf("unexpected session %+v", session)
+	}
+}
+
+func TestEscalateIngestHangUpWithoutDTMF(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	useSIPStandIn(t, hangUp)
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", "https://example.com/q1")).expect(t, 200, "ingest_created")
+
+	EscalateIngest(mustFindIngest(t, 1))
+	escalationCalls.Wait()
+
+	if ingest := mustFindIngest(t, 1); ingest.Status != IngestStatusPending {
+		t.Errorf("ingest status = %q after hang-up, want %q", ingest.Status, IngestStatusPending)
+	}
+
+	// Each ingest is called once
+	EscalateIngest(mustFindIngest(t, 1))
+	escalationCalls.Wait()
+
+	var entries []NotificationLedger
+	if err := DB.Where("channel = ?", NOTIFICATION_CHANNEL_SIP).Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Attempts != 1 {
+		t.Errorf("unexpected ledger entries %+v", entries)
+	}
+}
+
+func TestEscalateIngestRetriesCallerSetup(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	AppConfig.SIPOncallURI = "sip:oncall@127.0.0.1:5060"
+	AppConfig.SIPEscalationDelay = 0
+	// Already in use, so the caller cannot bind
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	AppConfig.SIPBindHost = "127.0.0.1"
+	AppConfig.SIPBindPort = conn.LocalAddr().(*net.UDPAddr).Port
+	callerMu.Lock()
+	caller = nil
+	callerMu.Unlock()
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", "https://example.com/q1")).expect(t, 200, "ingest_created")
+
+	EscalateIngest(mustFindIngest(t, 1))
+	escalationCalls.Wait()
+
+	var entry NotificationLedger
+	if err := DB.Where("channel = ?", NOTIFICATION_CHANNEL_SIP).First(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != NotificationStatusFailed || entry.Attempts != 0 {
+		t.Errorf("unexpected ledger entry %+v", entry)
+	}
+	if !entry.NextSendAt.After(time.Now()) {
+		t.Errorf("next_send_at = %v, want a retry later", entry.NextSendAt)
+	}
+}
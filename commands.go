@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MIGRATE_USAGE = "usage: migrate status | up [version] | down [steps]"
+
+// RunMigrateCommand implements the `migrate` subcommand. Only DATABASE_URL
+// is needed, so the rest of the configuration is not validated.
+func RunMigrateCommand(cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(MIGRATE_USAGE)
+	}
+
+	if err := OpenDB(cfg.DatabaseURL); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		states, err := GetMigrationStatus()
+		if err != nil {
+			return fmt.Errorf("failed to read migration status: %v", err)
+		}
+
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = "applied " + state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-40s %s\n", state.Migration.Version, state.Migration.Name, applied)
+		}
+		return nil
+
+	case "up":
+		target := 0
+		if len(args) > 1 {
+			version, err := strconv.Atoi(args[1])
+			if err != nil || version < 1 {
+				return fmt.Errorf("invalid target version %q", args[1])
+			}
+			target = version
+		}
+		return MigrateUp(target)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+		return MigrateDown(steps)
+	}
+
+	return fmt.Errorf(MIGRATE_USAGE)
+}
+
+const QUIZ_SOURCE_USAGE = `usage: quiz-source list
+       quiz-source set <name> -start-url URL -submit-url URL [-correct-field F] [-url-field F] [-reason-field F] [-delay-field F]
+       quiz-source activate <name>
+       quiz-source delete <name>`
+
+// RunQuizSourceCommand implements the `quiz-source` subcommand for managing
+// grader profiles.
+func RunQuizSourceCommand(cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(QUIZ_SOURCE_USAGE)
+	}
+
+	if err := InitDB(cfg.DatabaseURL); err != nil {
+		return err
+	}
+
+	// Seed the default first so it stays active when another source is added
+	if err := EnsureDefaultQuizSource(cfg); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		sources, err := ListQuizSources()
+		if err != nil {
+			return err
+		}
+
+		for _, source := range sources {
+			marker := " "
+			if source.Active {
+				marker = "*"
+			}
+			source.withSchemaDefaults()
+			fmt.Printf("%s %-16s start=%s submit=%s fields=%s,%s,%s,%s\n", marker, source.Name, source.StartURL, source.SubmitURL,
+				source.CorrectField, source.URLField, source.ReasonField, source.DelayField)
+		}
+		return nil
+
+	case "set":
+		if len(args) < 2 {
+			return fmt.Errorf(QUIZ_SOURCE_USAGE)
+		}
+
+		source := QuizSource{Name: args[1]}
+		if existing, err := GetQuizSource(args[1]); err == nil {
+			source = *existing
+		}
+
+		flags := flag.NewFlagSet("quiz-source set", flag.ContinueOnError)
+		flags.StringVar(&source.StartURL, "start-url", source.StartURL, "URL sent as `url` in the initial submission")
+		flags.StringVar(&source.SubmitURL, "submit-url", source.SubmitURL, "endpoint the initial submission is posted to")
+		flags.StringVar(&source.CorrectField, "correct-field", source.CorrectField, "response field holding the verdict")
+		flags.StringVar(&source.URLField, "url-field", source.URLField, "response field holding the next question URL")
+		flags.StringVar(&source.ReasonField, "reason-field", source.ReasonField, "response field holding the reason")
+		flags.StringVar(&source.DelayField, "delay-field", source.DelayField, "response field holding the delay")
+		if err := flags.Parse(args[2:]); err != nil {
+			return err
+		}
+
+		return SaveQuizSource(&source)
+
+	case "activate":
+		if len(args) < 2 {
+			return fmt.Errorf(QUIZ_SOURCE_USAGE)
+		}
+		return ActivateQuizSource(args[1])
+
+	case "delete":
+		if len(args) < 2 {
+			return fmt.Errorf(QUIZ_SOURCE_USAGE)
+		}
+		return DeleteQuizSource(args[1])
+	}
+
+	return fmt.Errorf(QUIZ_SOURCE_USAGE)
+}
+
+// RunMockGraderCommand implements the `mock-grader` subcommand, which serves
+// a local quiz for rehearsing the whole flow offline. Point a quiz source at
+// it with `quiz-source set mock -start-url <base>/start -submit-url <base>/submit`.
+func RunMockGraderCommand(args []string) error {
+	flags := flag.NewFlagSet("mock-grader", flag.ContinueOnError)
+	addr := flags.String("addr", ":9000", "address to listen on")
+	baseURL := flags.String("base-url", "", "URL clients use to reach the grader (default http://<addr>, with localhost for an empty or wildcard host)")
+	steps := flags.Int("steps", 3, "number of generated questions")
+	questionsFile := flags.String("questions", "", "JSON file of {question, answer} objects, instead of generated ones")
+	window := flags.Duration("window", 3*time.Minute, "how long each question stays open")
+	secret := flags.String("secret", "", "secret required on submissions (empty accepts any)")
+	encoded := flags.Bool("encoded", false, "serve questions as base64 decoded by a script, like the real grader")
+	ingestURL := flags.String("ingest-url", "", "our /ingest endpoint; when set, a run is kicked off at startup")
+	email := flags.String("email", "rehearsal@example.com", "email used for the kicked-off run")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	opts := MockGraderOptions{BaseURL: *baseURL, Window: *window, Secret: *secret, Encoded: *encoded}
+	if opts.BaseURL == "" {
+		host, port, err := net.SplitHostPort(*addr)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %v", *addr, err)
+		}
+		// A wildcard address is reached through localhost
+		if host == "" || net.ParseIP(host).IsUnspecified() {
+			host = "localhost"
+		}
+		opts.BaseURL = "http://" + net.JoinHostPort(host, port)
+	}
+
+	if *questionsFile != "" {
+		questions, err := LoadMockQuestions(*questionsFile)
+		if err != nil {
+			return err
+		}
+		opts.Questions = questions
+	} else {
+		if *steps < 1 {
+			return fmt.Errorf("invalid number of steps %d", *steps)
+		}
+		opts.Questions = DefaultMockQuestions(*steps)
+	}
+
+	grader := NewMockGrader(opts)
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Mock grader serving %d questions at %s (start %s, submit %s)",
+		len(opts.Questions), opts.BaseURL, grader.StartURL(), grader.SubmitURL())
+
+	if *ingestURL != "" {
+		go func() {
+			if err := grader.KickOff(*ingestURL, *email, *secret); err != nil {
+				log.Printf("Failed to kick off mock run: %v", err)
+				return
+			}
+			log.Printf("Kicked off mock run for %s at %s", *email, grader.QuestionURL(0))
+		}()
+	}
+
+	return http.Serve(listener, grader)
+}
+
+const OPERATOR_USAGE = `usage: operator list
+       operator set <username> [-role viewer|acceptor|solver|admin] [-password P]   (reads the password from stdin when omitted)
+       operator role <username> <viewer|acceptor|solver|admin>
+       operator delete <username>`
+
+// RunOperatorCommand implements the `operator` subcommand for managing
+// dashboard accounts.
+func RunOperatorCommand(cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(OPERATOR_USAGE)
+	}
+
+	if err := InitDB(cfg.DatabaseURL); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		operators, err := ListOperators()
+		if err != nil {
+			return err
+		}
+
+		for _, operator := range operators {
+			fmt.Printf("%-24s %-9s created %s\n", operator.Username, operator.Role, operator.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+
+	case "set":
+		if len(args) < 2 {
+			return fmt.Errorf(OPERATOR_USAGE)
+		}
+
+		flags := flag.NewFlagSet("operator set", flag.ContinueOnError)
+		password := flags.String("password", "", "new password (prefer stdin, flags show up in ps)")
+		role := flags.String("role", "", "viewer, acceptor, solver or admin (default: keep the current role, viewer for new operators)")
+		if err := flags.Parse(args[2:]); err != nil {
+			return err
+		}
+
+		if *password == "" {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("failed to read password from stdin: %v", err)
+			}
+			*password = strings.TrimRight(line, "\r\n")
+		}
+
+		if _, err := SaveOperator(args[1], *password, OperatorRole(*role)); err != nil {
+			return err
+		}
+		fmt.Printf("Operator %s saved\n", args[1])
+		return nil
+
+	case "role":
+		if len(args) < 3 {
+			return fmt.Errorf(OPERATOR_USAGE)
+		}
+		return SetOperatorRole(args[1], OperatorRole(args[2]))
+
+	case "delete":
+		if len(args) < 2 {
+			return fmt.Errorf(OPERATOR_USAGE)
+		}
+		return DeleteOperator(args[1])
+	}
+
+	return fmt.Errorf(OPERATOR_USAGE)
+}
+
+// RunRotateKeysCommand implements the `rotate-keys` subcommand. After a new
+// key is put first in SECRET_ENCRYPTION_KEYS, it re-encrypts every stored
+// secret under it, after which the old keys can be removed.
+func RunRotateKeysCommand(cfg *Config, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: rotate-keys")
+	}
+	if len(cfg.EncryptionKeys) == 0 {
+		return fmt.Errorf("SECRET_ENCRYPTION_KEYS is required")
+	}
+
+	if err := InitDB(cfg.DatabaseURL); err != nil {
+		return err
+	}
+
+	ingests, sessions, err := RotateSecretKeys()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Re-encrypted %d ingest and %d quiz session secrets with key %q\n", ingests, sessions, cfg.EncryptionKeys[0].ID)
+	return nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Config holds every setting the server reads at startup. Values come from,
+// in increasing priority: built-in defaults, the file named by CONFIG_FILE,
+// and environment variables (including .env). Config files use the same
+// keys as the environment, e.g. `QUIZ_WINDOW: 3m`.
+type Config struct {
+	Port        string
+	DatabaseURL string
+
+	// Reverse proxies whose X-Forwarded-For header is believed
+	TrustedProxies []string
+
+	Secret               string
+	IngestAcceptPassword string
+	QuizAttemptPassword  string
+	SubmissionEmail      string
+
+	PublicBaseURL     string
+	AcceptTokenSecret string
+
+	// Keys that encrypt participant secrets at rest; the first seals new ones
+	EncryptionKeys []EncryptionKey
+
+	// How long an operator stays logged in to the dashboard
+	OperatorSessionTTL time.Duration
+
+	// How long an ingest waits to be accepted, and how long each question stays open
+	QuizWindow time.Duration
+
+	// How long to wait for a question page; 0 turns question fetching off
+	QuestionFetchTimeout time.Duration
+
+	GraderStartURL  string
+	GraderSubmitURL string
+
+	NotifyChannels    []string
+	NtfyURL           string
+	NtfyTopic         string
+	NtfyToken         string
+	WebhookURL        string
+	SlackWebhookURL   string
+	DiscordWebhookURL string
+	TelegramBotToken  string
+	TelegramChatID    string
+	TelegramAPIURL    string
+	SMTPHost          string
+	SMTPPort          string
+	SMTPUsername      string
+	SMTPPassword      string
+	SMTPFrom          string
+	SMTPTo            []string
+
+	SIPOncallURI       string
+	SIPUsername        string
+	SIPPassword        string
+	SIPBindHost        string
+	SIPBindPort        int
+	SIPEscalationDelay time.Duration
+	SIPCallDuration    time.Duration
+}
+
+var AppConfig *Config
+
+type configSetting[T any] struct {
+	Key   string
+	Value T
+}
+
+// Keys accepted in the environment and in config files, with their defaults
+var configDefaults = map[string]string{
+	"PORT":                   "8080",
+	"DATABASE_URL":           DEFAULT_DATABASE_URL,
+	"TRUSTED_PROXIES":        "",
+	"SECRET":                 "",
+	"INGEST_ACCEPT_PASSWORD": "",
+	"QUIZ_ATTEMPT_PASSWORD":  "",
+	"SUBMISSION_EMAIL":       "",
+	"PUBLIC_BASE_URL":        "",
+	"ACCEPT_TOKEN_SECRET":    "",
+	"SECRET_ENCRYPTION_KEYS": "",
+	"OPERATOR_SESSION_TTL":   "12h",
+	"QUIZ_WINDOW":            "3m",
+	"QUESTION_FETCH_TIMEOUT": "10s",
+	"GRADER_START_URL":       "https://tds-llm-analysis.s-anand.net/project2",
+	"GRADER_SUBMIT_URL":      "https://tds-llm-analysis.s-anand.net/submit",
+	"NOTIFY_CHANNELS":        "",
+	"NTFY_URL":               "https://ntfy.sh",
+	"NTFY_TOPIC":             "",
+	"NTFY_TOKEN":             "",
+	"WEBHOOK_URL":            "",
+	"SLACK_WEBHOOK_URL":      "",
+	"DISCORD_WEBHOOK_URL":    "",
+	"TELEGRAM_BOT_TOKEN":     "",
+	"TELEGRAM_CHAT_ID":       "",
+	"TELEGRAM_API_URL":       "https://api.telegram.org",
+	"SMTP_HOST":              "",
+	"SMTP_PORT":              "587",
+	"SMTP_USERNAME":          "",
+	"SMTP_PASSWORD":          "",
+	"SMTP_FROM":              "",
+	"SMTP_TO":                "",
+	"SIP_ONCALL_URI":         "",
+	"SIP_USERNAME":           "",
+	"SIP_PASSWORD":           "",
+	"SIP_BIND_HOST":          "",
+	"SIP_BIND_PORT":          "0",
+	"SIP_ESCALATION_DELAY":   DEFAULT_ESCALATION_DELAY.String(),
+	"SIP_CALL_DURATION":      DEFAULT_CALL_DURATION.String(),
+}
+
+// LoadConfig reads the configuration. It fails on values that cannot be
+// parsed; Validate checks that the result is usable for serving.
+func LoadConfig() (*Config, error) {
+	values := map[string]string{}
+	for key, value := range configDefaults {
+		values[key] = value
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := readConfigFile(path, values); err != nil {
+			return nil, err
+		}
+	}
+
+	// Empty variables count as unset, so a blank line in .env does not
+	// wipe out a value from the config file
+	for key := range configDefaults {
+		if value := os.Getenv(key); value != "" {
+			values[key] = value
+		}
+	}
+
+	var errs []error
+	duration := func(key string) time.Duration {
+		d, err := time.ParseDuration(values[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", key, values[key]))
+		}
+		return d
+	}
+
+	cfg := &Config{
+		Port:        values["PORT"],
+		DatabaseURL: values["DATABASE_URL"],
+
+		TrustedProxies: splitList(values["TRUSTED_PROXIES"]),
+
+		Secret:               values["SECRET"],
+		IngestAcceptPassword: values["INGEST_ACCEPT_PASSWORD"],
+		QuizAttemptPassword:  values["QUIZ_ATTEMPT_PASSWORD"],
+		SubmissionEmail:      values["SUBMISSION_EMAIL"],
+
+		PublicBaseURL:     strings.TrimRight(values["PUBLIC_BASE_URL"], "/"),
+		AcceptTokenSecret: values["ACCEPT_TOKEN_SECRET"],
+
+		OperatorSessionTTL: duration("OPERATOR_SESSION_TTL"),
+
+		QuizWindow: duration("QUIZ_WINDOW"),
+
+		QuestionFetchTimeout: duration("QUESTION_FETCH_TIMEOUT"),
+
+		GraderStartURL:  values["GRADER_START_URL"],
+		GraderSubmitURL: values["GRADER_SUBMIT_URL"],
+
+		NotifyChannels:    splitList(values["NOTIFY_CHANNELS"]),
+		NtfyURL:           strings.TrimRight(values["NTFY_URL"], "/"),
+		NtfyTopic:         values["NTFY_TOPIC"],
+		NtfyToken:         values["NTFY_TOKEN"],
+		WebhookURL:        values["WEBHOOK_URL"],
+		SlackWebhookURL:   values["SLACK_WEBHOOK_URL"],
+		DiscordWebhookURL: values["DISCORD_WEBHOOK_URL"],
+		TelegramBotToken:  values["TELEGRAM_BOT_TOKEN"],
+		TelegramChatID:    values["TELEGRAM_CHAT_ID"],
+		TelegramAPIURL:    strings.TrimRight(values["TELEGRAM_API_URL"], "/"),
+		SMTPHost:          values["SMTP_HOST"],
+		SMTPPort:          values["SMTP_PORT"],
+		SMTPUsername:      values["SMTP_USERNAME"],
+		SMTPPassword:      values["SMTP_PASSWORD"],
+		SMTPFrom:          values["SMTP_FROM"],
+		SMTPTo:            splitList(values["SMTP_TO"]),
+
+		SIPOncallURI:       values["SIP_ONCALL_URI"],
+		SIPUsername:        values["SIP_USERNAME"],
+		SIPPassword:        values["SIP_PASSWORD"],
+		SIPBindHost:        values["SIP_BIND_HOST"],
+		SIPEscalationDelay: duration("SIP_ESCALATION_DELAY"),
+		SIPCallDuration:    duration("SIP_CALL_DURATION"),
+	}
+
+	encryptionKeys, err := parseEncryptionKeys(values["SECRET_ENCRYPTION_KEYS"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("SECRET_ENCRYPTION_KEYS: %v", err))
+	}
+	cfg.EncryptionKeys = encryptionKeys
+
+	bindPort, err := strconv.Atoi(values["SIP_BIND_PORT"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("SIP_BIND_PORT: invalid port %q", values["SIP_BIND_PORT"]))
+	}
+	cfg.SIPBindPort = bindPort
+
+	// Without an explicit list, ntfy stays the default channel
+	if len(cfg.NotifyChannels) == 0 && cfg.NtfyTopic != "" {
+		cfg.NotifyChannels = []string{NOTIFICATION_CHANNEL_NTFY}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate reports every problem that would stop the server from working,
+// so a misconfigured deployment fails at boot instead of at first use.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	for _, setting := range []configSetting[string]{
+		{"SECRET", c.Secret},
+		{"INGEST_ACCEPT_PASSWORD", c.IngestAcceptPassword},
+		{"QUIZ_ATTEMPT_PASSWORD", c.QuizAttemptPassword},
+	} {
+		if setting.Value == "" {
+			fail("%s is required", setting.Key)
+		}
+	}
+
+	if len(c.EncryptionKeys) == 0 {
+		fail("SECRET_ENCRYPTION_KEYS is required")
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		fail("PORT: invalid port %q", c.Port)
+	}
+
+	if _, err := openDialector(c.DatabaseURL); err != nil {
+		fail("DATABASE_URL: %v", err)
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			fail("TRUSTED_PROXIES: %q is not an IP address or CIDR range", proxy)
+		}
+	}
+
+	for _, setting := range []configSetting[string]{
+		{"PUBLIC_BASE_URL", c.PublicBaseURL},
+		{"GRADER_START_URL", c.GraderStartURL},
+		{"GRADER_SUBMIT_URL", c.GraderSubmitURL},
+		{"NTFY_URL", c.NtfyURL},
+		{"WEBHOOK_URL", c.WebhookURL},
+		{"SLACK_WEBHOOK_URL", c.SlackWebhookURL},
+		{"DISCORD_WEBHOOK_URL", c.DiscordWebhookURL},
+		{"TELEGRAM_API_URL", c.TelegramAPIURL},
+	} {
+		if setting.Value != "" && !isHTTPURL(setting.Value) {
+			fail("%s: %q is not an http(s) URL", setting.Key, setting.Value)
+		}
+	}
+
+	for _, setting := range []configSetting[time.Duration]{
+		{"QUIZ_WINDOW", c.QuizWindow},
+		{"OPERATOR_SESSION_TTL", c.OperatorSessionTTL},
+		{"SIP_ESCALATION_DELAY", c.SIPEscalationDelay},
+		{"SIP_CALL_DURATION", c.SIPCallDuration},
+	} {
+		if setting.Value <= 0 {
+			fail("%s must be positive", setting.Key)
+		}
+	}
+
+	if c.QuestionFetchTimeout < 0 {
+		fail("QUESTION_FETCH_TIMEOUT must not be negative")
+	}
+
+	if port, err := strconv.Atoi(c.SMTPPort); err != nil || port < 1 || port > 65535 {
+		fail("SMTP_PORT: invalid port %q", c.SMTPPort)
+	}
+
+	if c.SIPBindPort < 0 || c.SIPBindPort > 65535 {
+		fail("SIP_BIND_PORT: invalid port %d", c.SIPBindPort)
+	}
+
+	if c.SIPOncallURI != "" && !strings.HasPrefix(c.SIPOncallURI, "sip:") && !strings.HasPrefix(c.SIPOncallURI, "sips:") {
+		fail("SIP_ONCALL_URI: %q is not a sip: URI", c.SIPOncallURI)
+	}
+
+	for _, channel := range c.NotifyChannels {
+		if _, err := newNotifier(c, channel); err != nil {
+			fail("NOTIFY_CHANNELS: %v", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// readConfigFile merges a YAML or TOML file of top-level keys into values.
+func readConfigFile(path string, values map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	for key, value := range raw {
+		key = strings.ToUpper(key)
+		if _, ok := configDefaults[key]; !ok {
+			return fmt.Errorf("config file %s: unknown key %q", path, key)
+		}
+
+		switch v := value.(type) {
+		case nil:
+			values[key] = ""
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// sameHost reports whether two URLs have the same host and port.
+func sameHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
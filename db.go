@@ -1,9 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -21,50 +24,203 @@ const (
 	IngestStatusFailed    IngestStatus = "Failed"
 )
 
+type QuizSessionStatus string
+
+const (
+	QuizSessionStatusWaiting   QuizSessionStatus = "waiting_for_answer"
+	QuizSessionStatusRunning   QuizSessionStatus = "running" // an answer is being submitted upstream
+	QuizSessionStatusCompleted QuizSessionStatus = "completed"
+	QuizSessionStatusFailed    QuizSessionStatus = "failed"
+)
+
 type Ingests struct {
 	ID     uint   `json:"id" gorm:"primaryKey;autoIncrement"`
 	Email  string `json:"email"`
-	Secret string `json:"-"`
+	Secret string `json:"-"` // encrypted, see secrets.go
 	URL    string `json:"url"`
-	Raw    string `json:"raw"`
+	Raw    string `json:"raw"` // the announcement as received, without the secret
 
-	Status    IngestStatus `json:"status"`
-	CreatedAt time.Time    `json:"createdAt" gorm:"autoCreateTime"`
-	Deadline  time.Time    `json:"deadline"`
+	SecretKeyID string `json:"-" gorm:"not null;default:''"`
+
+	QuizSourceID *uint `json:"quizSourceId"`
+
+	// Username of the operator who accepted or started it, if any
+	AcceptedBy string `json:"acceptedBy"`
+
+	Status          IngestStatus `json:"status"`
+	StatusChangedAt time.Time    `json:"statusChangedAt"`
+	FailureReason   string       `json:"failureReason"`
+	CreatedAt       time.Time    `json:"createdAt" gorm:"autoCreateTime"`
+	Deadline        time.Time    `json:"deadline"`
 }
 
-type QuizSession struct {
+// QuizSource is an upstream grader profile: where the initial submission
+// goes and how the grader's responses are shaped. Exactly one is active and
+// used for new ingests.
+type QuizSource struct {
+	ID        uint   `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name      string `json:"name" gorm:"uniqueIndex"`
+	StartURL  string `json:"startUrl"`
+	SubmitURL string `json:"submitUrl"`
+
+	// JSON paths (dot-separated) of the response fields
+	CorrectField string `json:"correctField"`
+	URLField     string `json:"urlField"`
+	ReasonField  string `json:"reasonField"`
+	DelayField   string `json:"delayField"`
+
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// Operator is a named dashboard account. Passwords are stored as bcrypt
+// hashes; the role decides which actions the operator may take.
+type Operator struct {
+	ID           uint         `json:"id" gorm:"primaryKey;autoIncrement"`
+	Username     string       `json:"username" gorm:"uniqueIndex"`
+	PasswordHash string       `json:"-"`
+	Role         OperatorRole `json:"role"`
+	CreatedAt    time.Time    `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time    `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// OperatorSession is a dashboard login. Only a hash of the cookie value is
+// stored; the CSRF token is sent back with every state-changing request.
+type OperatorSession struct {
 	ID         uint      `json:"id" gorm:"primaryKey;autoIncrement"`
-	IngestID   uint      `json:"ingestId"`
-	Email      string    `json:"email"`
-	Secret     string    `json:"-"`
-	CurrentURL string    `json:"currentUrl"`
-	Status     string    `json:"status"` // "running", "completed", "failed"
+	OperatorID uint      `json:"operatorId" gorm:"not null;index"`
+	TokenHash  string    `json:"-" gorm:"uniqueIndex"`
+	CSRFToken  string    `json:"-"`
+	ExpiresAt  time.Time `json:"expiresAt" gorm:"index"`
 	CreatedAt  time.Time `json:"createdAt" gorm:"autoCreateTime"`
-	UpdatedAt  time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+type QuizSession struct {
+	ID              uint              `json:"id" gorm:"primaryKey;autoIncrement"`
+	IngestID        uint              `json:"ingestId" gorm:"not null;index"`
+	Email           string            `json:"email"`
+	Secret          string            `json:"-"` // encrypted, see secrets.go
+	SecretKeyID     string            `json:"-" gorm:"not null;default:''"`
+	CurrentURL      string            `json:"currentUrl"`
+	Status          QuizSessionStatus `json:"status" gorm:"index"`
+	StatusChangedAt time.Time         `json:"statusChangedAt"`
+	FailureReason   string            `json:"failureReason"`
+	CreatedAt       time.Time         `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt       time.Time         `json:"updatedAt" gorm:"autoUpdateTime"`
+
+	// Only loaded when asked for, see ListQuizSessions
+	Attempts []QuizAttempt `json:"attempts,omitempty" gorm:"foreignKey:SessionID"`
 }
 
 type QuizAttempt struct {
-	ID          uint      `json:"id" gorm:"primaryKey;autoIncrement"`
-	SessionID   uint      `json:"sessionId"`
-	URL         string    `json:"url"`
-	Question    string    `json:"question"`
-	Answer      string    `json:"answer" gorm:"default:''"`
-	SubmitURL   string    `json:"submitUrl"`
-	Correct     *bool     `json:"correct" gorm:"default:null"`
-	NextURL     string    `json:"nextUrl"`
-	Reason      string    `json:"reason"`
-	ResponseRaw string    `json:"responseRaw"`
-	Deadline    time.Time `json:"deadline"` // 3 minutes from creation for attempts
-	CreatedAt   time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	ID           uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	SessionID    uint       `json:"sessionId" gorm:"not null;index:idx_quiz_attempts_session_created,priority:1"`
+	URL          string     `json:"url"`
+	Question     string     `json:"question"` // visible text of the page once fetched
+	QuestionHTML string     `json:"-"`        // the page as fetched, served by GetQuestionPage
+	Answer       string     `json:"answer" gorm:"default:''"`
+	SubmitURL    string     `json:"submitUrl"`   // found on the question page until answered, then the URL used
+	SubmittedBy  string     `json:"submittedBy"` // username of the operator who answered
+	Correct      *bool      `json:"correct" gorm:"default:null"`
+	NextURL      string     `json:"nextUrl"`
+	Reason       string     `json:"reason"`
+	ResponseRaw  string     `json:"responseRaw"`
+	Deadline     time.Time  `json:"deadline" gorm:"index"` // one quiz window from creation
+	ExpiredAt    *time.Time `json:"expiredAt"`             // set by the sweeper when the deadline passes unanswered
+	ClaimedAt    *time.Time `json:"claimedAt"`             // set while an answer is being submitted upstream
+	FetchedAt    *time.Time `json:"fetchedAt"`             // when the question page was fetched
+	FetchError   string     `json:"fetchError"`
+	CreatedAt    time.Time  `json:"createdAt" gorm:"autoCreateTime;index:idx_quiz_attempts_session_created,priority:2"`
+}
+
+// StatusHistory records every status change of an ingest or quiz session.
+type StatusHistory struct {
+	ID         uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	EntityType string    `json:"entityType" gorm:"index:idx_status_history_entity"`
+	EntityID   uint      `json:"entityId" gorm:"index:idx_status_history_entity"`
+	FromStatus string    `json:"fromStatus"`
+	ToStatus   string    `json:"toStatus"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+type NotificationStatus string
+
+const (
+	NotificationStatusPending NotificationStatus = "pending"
+	NotificationStatusSent    NotificationStatus = "sent"
+	NotificationStatusFailed  NotificationStatus = "failed"
+	NotificationStatusStopped NotificationStatus = "stopped"
+)
+
+// NotificationLedger tracks delivery of announcements per ingest and channel
+// so NotifyJob can back off instead of re-sending on every tick.
+type NotificationLedger struct {
+	ID         uint               `json:"id" gorm:"primaryKey;autoIncrement"`
+	IngestID   uint               `json:"ingestId" gorm:"uniqueIndex:idx_ledger_ingest_channel"`
+	Channel    string             `json:"channel" gorm:"uniqueIndex:idx_ledger_ingest_channel"`
+	Attempts   int                `json:"attempts"`
+	LastSentAt time.Time          `json:"lastSentAt"`
+	NextSendAt time.Time          `json:"nextSendAt"`
+	Status     NotificationStatus `json:"status"`
+	LastError  string             `json:"lastError"`
+	CreatedAt  time.Time          `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time          `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// AcceptToken backs the one-tap accept links sent with notifications. The
+// token itself is HMAC-signed; the row makes it single-use.
+type AcceptToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	IngestID  uint       `json:"ingestId" gorm:"index"`
+	Nonce     string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// IdempotencyRecord stores the response to a request made with an
+// idempotency key so retries can be answered without side effects.
+type IdempotencyRecord struct {
+	ID             uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Scope          string    `json:"scope" gorm:"uniqueIndex:idx_idempotency_scope_key"`
+	IdempotencyKey string    `json:"idempotencyKey" gorm:"uniqueIndex:idx_idempotency_scope_key"`
+	RequestHash    string    `json:"requestHash"`
+	Status         string    `json:"status"`
+	StatusCode     int       `json:"statusCode"`
+	ResponseBody   string    `json:"responseBody"`
+	ExpiresAt      time.Time `json:"expiresAt" gorm:"index"`
+	CreatedAt      time.Time `json:"createdAt" gorm:"autoCreateTime"`
 }
 
 var DB *gorm.DB
 
-func InitDB() error {
-	var err error
+// InitDB opens the database and applies any pending migrations.
+func InitDB(databaseURL string) error {
+	if err := OpenDB(databaseURL); err != nil {
+		return err
+	}
 
-	DB, err = gorm.Open(sqlite.Open("data/app.db"), &gorm.Config{
+	if err := MigrateUp(0); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+const DEFAULT_DATABASE_URL = "sqlite://data/app.db"
+
+// OpenDB connects to the database named by databaseURL, which is either
+// sqlite://<path> or a postgres:// connection URL.
+func OpenDB(databaseURL string) error {
+	dialector, err := openDialector(databaseURL)
+	if err != nil {
+		return err
+	}
+
+	DB, err = gorm.Open(dialector, &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Warn),
 	})
 
@@ -73,28 +229,62 @@ func InitDB() error {
 		return err
 	}
 
-	if err := DB.AutoMigrate(&Ingests{}, &QuizSession{}, &QuizAttempt{}); err != nil {
-		log.Fatalf("AutoMigrate failed: %v", err)
-		return err
-	}
+	return nil
+}
 
-	// Migration: Set deadline for existing attempts that don't have one
-	if err := migrateExistingAttempts(); err != nil {
-		log.Printf("Warning: Failed to migrate existing attempts: %v", err)
+func openDialector(databaseURL string) (gorm.Dialector, error) {
+	scheme, rest, ok := strings.Cut(databaseURL, "://")
+	if !ok {
+		return nil, fmt.Errorf("DATABASE_URL must start with sqlite:// or postgres://")
 	}
 
-	return nil
-}
+	switch scheme {
+	case "postgres", "postgresql":
+		return postgres.Open(databaseURL), nil
 
-func migrateExistingAttempts() error {
-	// Update attempts with empty/null deadlines to have a deadline 3 minutes from creation
-	var count int64
-	DB.Model(&QuizAttempt{}).Where("deadline IS NULL OR deadline = ?", time.Time{}).Count(&count)
+	case "sqlite":
+		if rest == "" {
+			return nil, fmt.Errorf("DATABASE_URL is missing the SQLite file path")
+		}
 
-	if count > 0 {
-		log.Printf("Migrating %d existing quiz attempts with missing deadlines", count)
-		return DB.Exec("UPDATE quiz_attempts SET deadline = datetime(created_at, '+3 minutes') WHERE deadline IS NULL OR deadline = ?", time.Time{}).Error
+		// Immediate transactions take the write lock up front, so concurrent
+		// answer submissions queue on the busy timeout instead of failing
+		separator := "?"
+		if strings.Contains(rest, "?") {
+			separator = "&"
+		}
+		return sqlite.Open(rest + separator + "_txlock=immediate&_busy_timeout=5000&_foreign_keys=1"), nil
 	}
 
-	return nil
+	return nil, fmt.Errorf("unsupported DATABASE_URL scheme %q", scheme)
+}
+
+// AuditEvent records a privileged action: who did it, from where, to which
+// records and how it ended. The table is append-only; the database rejects
+// updates and deletes. Target IDs are plain columns so events outlive the
+// records they mention.
+type AuditEvent struct {
+	ID          uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Actor       string    `json:"actor" gorm:"index"`
+	Action      string    `json:"action" gorm:"index"`
+	IngestID    *uint     `json:"ingestId" gorm:"index"`
+	SessionID   *uint     `json:"sessionId" gorm:"index"`
+	AttemptID   *uint     `json:"attemptId"`
+	ClientIP    string    `json:"clientIp"`
+	PayloadHash string    `json:"payloadHash"` // SHA-256 of the request, without passwords and secrets
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error"`
+	CreatedAt   time.Time `json:"createdAt" gorm:"autoCreateTime;index"`
+}
+
+// FailedAttemptCounter counts failed password and secret guesses by one
+// subject: a client IP ("ip:<addr>") or a target such as "accept:<ingest>".
+type FailedAttemptCounter struct {
+	ID            uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Subject       string    `json:"subject" gorm:"uniqueIndex"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"lastFailureAt"`
+	LockedUntil   time.Time `json:"lockedUntil" gorm:"index"`
+	CreatedAt     time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt     time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	EVENT_INGEST_CREATED    = "ingest.created"
+	EVENT_INGEST_ACCEPTED   = "ingest.accepted"
+	EVENT_ATTEMPT_CREATED   = "attempt.created"
+	EVENT_ATTEMPT_FETCHED   = "attempt.fetched"
+	EVENT_ATTEMPT_ANSWERED  = "attempt.answered"
+	EVENT_ATTEMPT_EXPIRED   = "attempt.expired"
+	EVENT_SESSION_COMPLETED = "session.completed"
+
+	// Events queued per subscriber before it is considered too slow
+	EVENT_BUFFER_SIZE = 64
+	// How often an idle stream sends a ping, so proxies keep it open
+	EVENT_PING_INTERVAL = 15 * time.Second
+)
+
+// DashboardEvent tells the dashboard that a record changed. Data is the
+// record after the change: an Ingests, QuizAttempt or QuizSession.
+type DashboardEvent struct {
+	ID   uint64 `json:"id"`
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// EventBroker fans dashboard events out to the open /events streams of
+// this process. Events are not persisted; a client that reconnects reloads
+// its state instead of replaying what it missed.
+type EventBroker struct {
+	mu          sync.Mutex
+	lastID      uint64
+	subscribers map[chan DashboardEvent]struct{}
+}
+
+var Events = NewEventBroker()
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: map[chan DashboardEvent]struct{}{}}
+}
+
+// Subscribe registers a stream. The channel is closed by unsubscribe, or
+// by Publish if the subscriber falls EVENT_BUFFER_SIZE events behind.
+func (b *EventBroker) Subscribe() (<-chan DashboardEvent, func()) {
+	ch := make(chan DashboardEvent, EVENT_BUFFER_SIZE)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish sends an event to every subscriber without blocking. It is called
+// after the change is committed.
+func (b *EventBroker) Publish(eventType string, data any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := DashboardEvent{ID: b.lastID, Type: eventType, Data: data}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Dropping one event would leave the client out of date, so
+			// end its stream and let it reconnect and reload instead
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// StreamEvents serves the Server-Sent Events stream behind GET /events.
+func StreamEvents(c *gin.Context) {
+	events, unsubscribe := Events.Subscribe()
+	defer unsubscribe()
+
+	ping := time.NewTicker(EVENT_PING_INTERVAL)
+	defer ping.Stop()
+
+	// Stop reverse proxies such as nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	c.Render(-1, sse.Event{Event: "ready", Data: currentOperator(c).Username})
+	c.Writer.Flush()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			c.Render(-1, sse.Event{
+				Id:    strconv.FormatUint(event.ID, 10),
+				Event: event.Type,
+				Data:  event,
+			})
+
+		case <-ping.C:
+			c.Render(-1, sse.Event{Event: "ping", Data: time.Now().Unix()})
+
+		case <-c.Request.Context().Done():
+			return
+		}
+
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type streamedEvent struct {
+	Type string
+	Data string
+}
+
+// openEventStream connects to GET /events over a real server, so the
+// stream is flushed as it would be to a browser, and waits for "ready".
+func openEventStream(t *testing.T, r http.Handler, auth []string) <-chan streamedEvent {
+	t.Helper()
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	req, _ := http.NewRequest("GET", server.URL+"/events", nil)
+	for i := 0; i+1 < len(auth); i += 2 {
+		req.Header.Set(auth[i], auth[i+1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	if res.StatusCode != 200 || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("GET /events: %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	events := make(chan streamedEvent, 16)
+	go func() {
+		defer close(events)
+
+		var event streamedEvent
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				event.Type = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:"):
+				event.Data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			case line == "":
+				events <- event
+				event = streamedEvent{}
+			}
+		}
+	}()
+
+	expectEvent(t, events, "ready")
+	return events
+}
+
+// expectEvent waits for the next event, which must be of the given type.
+func expectEvent(t *testing.T, events <-chan streamedEvent, eventType string) DashboardEvent {
+	t.Helper()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("stream closed while waiting for %s", eventType)
+			}
+			if event.Type == "ping" {
+				continue
+			}
+			if event.Type != eventType {
+				t.Fatalf("got event %s, want %s: %s", event.Type, eventType, event.Data)
+			}
+
+			var decoded DashboardEvent
+			if eventType != "ready" {
+				if err := json.Unmarshal([]byte(event.Data), &decoded); err != nil {
+					t.Fatalf("decode %s: %v", eventType, err)
+				}
+			}
+			return decoded
+
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s", eventType)
+		}
+	}
+}
+
+func eventRecord[T any](t *testing.T, event DashboardEvent) T {
+	t.Helper()
+
+	var record T
+	data, _ := json.Marshal(event.Data)
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("decode %s data: %v", event.Type, err)
+	}
+	return record
+}
+
+func TestEventStream(t *testing.T) {
+	r := newTestRouter(t)
+	grader := newTestGrader(t, MockGraderOptions{Questions: DefaultMockQuestions(2)})
+	auth := login(t, r, testOperator, testOperatorPass)
+	events := openEventStream(t, r, auth)
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", grader.QuestionURL(0))).expect(t, 200, "ingest_created")
+	if ingest := eventRecord[Ingests](t, expectEvent(t, events, EVENT_INGEST_CREATED)); ingest.ID != 1 || ingest.Status != IngestStatusPending {
+		t.Errorf("unexpected created ingest %+v", ingest)
+	}
+
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword), auth...).
+		expect(t, 200, "ingest_accepted_and_quiz_started")
+	if ingest := eventRecord[Ingests](t, expectEvent(t, events, EVENT_INGEST_ACCEPTED)); ingest.Status != IngestStatusRunning || ingest.AcceptedBy != testOperator {
+		t.Errorf("unexpected accepted ingest %+v", ingest)
+	}
+	first := eventRecord[QuizAttempt](t, expectEvent(t, events, EVENT_ATTEMPT_CREATED))
+
+	session := mustFindSession(t, 1)
+	withAuth := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for i := 0; i+1 < len(auth); i += 2 {
+			req.Header.Set(auth[i], auth[i+1])
+		}
+		r.ServeHTTP(w, req)
+	})
+
+	// A wrong answer is recorded and a retry attempt opens
+	answer(t, withAuth, grader, session, 0, 1).expect(t, 200, "answer_submitted")
+	if answered := eventRecord[QuizAttempt](t, expectEvent(t, events, EVENT_ATTEMPT_ANSWERED)); answered.ID != first.ID || answered.Correct == nil || *answered.Correct {
+		t.Errorf("unexpected answered attempt %+v", answered)
+	}
+	expectEvent(t, events, EVENT_ATTEMPT_CREATED)
+
+	answer(t, withAuth, grader, session, 0, 13).expect(t, 200, "answer_submitted")
+	expectEvent(t, events, EVENT_ATTEMPT_ANSWERED)
+	if next := eventRecord[QuizAttempt](t, expectEvent(t, events, EVENT_ATTEMPT_CREATED)); next.URL != grader.QuestionURL(1) {
+		t.Errorf("next attempt URL = %q, want %q", next.URL, grader.QuestionURL(1))
+	}
+
+	answer(t, withAuth, grader, session, 1, 23).expect(t, 200, "answer_submitted")
+	expectEvent(t, events, EVENT_ATTEMPT_ANSWERED)
+	if completed := eventRecord[QuizSession](t, expectEvent(t, events, EVENT_SESSION_COMPLETED)); completed.ID != session.ID || completed.Status != QuizSessionStatusCompleted {
+		t.Errorf("unexpected completed session %+v", completed)
+	}
+}
+
+func TestEventStreamExpiredAttempts(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{})
+	createRunningSession(t, r, grader, "a@example.com")
+
+	events := openEventStream(t, r, nil)
+
+	backdateAttempts(t)
+	SweepJob()
+
+	if expired := eventRecord[QuizAttempt](t, expectEvent(t, events, EVENT_ATTEMPT_EXPIRED)); expired.ExpiredAt == nil {
+		t.Errorf("expired attempt has no expiredAt: %+v", expired)
+	}
+
+	// Already expired attempts are not announced again
+	SweepJob()
+	select {
+	case event := <-events:
+		if event.Type != "ping" {
+			t.Errorf("unexpected event %s after second sweep", event.Type)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventStreamRequiresLogin(t *testing.T) {
+	r := newTestRouter(t)
+
+	doRequest(t, r, "GET", "/events", nil).expect(t, 401, "unauthenticated")
+}
+
+func TestEventBrokerDropsSlowSubscribers(t *testing.T) {
+	broker := NewEventBroker()
+	slow, _ := broker.Subscribe()
+	fast, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i <= EVENT_BUFFER_SIZE; i++ {
+		broker.Publish(EVENT_INGEST_CREATED, i)
+		<-fast
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != EVENT_BUFFER_SIZE {
+		t.Errorf("slow subscriber got %d events before being closed, want %d", received, EVENT_BUFFER_SIZE)
+	}
+
+	broker.Publish(EVENT_INGEST_CREATED, "after")
+	if event := <-fast; event.Data != "after" {
+		t.Errorf("fast subscriber got %+v", event)
+	}
+}
+
+func TestExpireAttemptsSkipsAnswerRecordedMeanwhile(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{})
+	session := createRunningSession(t, r, grader, "a@example.com")
+	backdateAttempts(t)
+
+	events := openEventStream(t, r, nil)
+
+	// The answer lands right after the sweep read the attempt
+	answered := false
+	recordAnswer := func(tx *gorm.DB) {
+		if tx.Statement.Table == "quiz_attempts" && !answered {
+			answered = true
+			tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE quiz_attempts SET answer = '13'")
+		}
+	}
+	if err := DB.Callback().Query().After("gorm:query").Register("test:record_answer", recordAnswer); err != nil {
+		t.Fatal(err)
+	}
+	err := expireAttempts(time.Now())
+	DB.Callback().Query().Remove("test:record_answer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attempt := mustFindAttempts(t, session.ID)[0]; attempt.ExpiredAt != nil {
+		t.Errorf("answered attempt was marked expired at %v", attempt.ExpiredAt)
+	}
+	select {
+	case event := <-events:
+		if event.Type != "ping" {
+			t.Errorf("unexpected event %s for an answered attempt", event.Type)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
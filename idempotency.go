@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+const IDEMPOTENCY_HEADER = "Idempotency-Key"
+
+// How long an explicit Idempotency-Key is remembered
+const IDEMPOTENCY_TTL = 24 * time.Hour
+
+const (
+	IDEMPOTENCY_STATUS_IN_PROGRESS = "in_progress"
+	IDEMPOTENCY_STATUS_COMPLETED   = "completed"
+)
+
+type IdempotencyOptions struct {
+	// Scope namespaces keys, e.g. per route or per session
+	Scope func(c *gin.Context) string
+	// DeriveKey builds a key from the request body when the header is absent.
+	// Returning "" disables idempotency for that request.
+	DeriveKey func(body []byte) string
+	// DerivedTTL is how long derived keys are remembered
+	DerivedTTL time.Duration
+}
+
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotent replays the stored response when a request is repeated with the
+// same key. Only successful responses are stored; failures release the key
+// so the client can retry.
+func Idempotent(opts IdempotencyOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bodyBytes, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, APIResponse[any]{
+				Status:  "error",
+				Message: "could_not_read_request_body",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+		hash := sha256.Sum256(bodyBytes)
+		requestHash := hex.EncodeToString(hash[:])
+
+		key := c.GetHeader(IDEMPOTENCY_HEADER)
+		ttl := IDEMPOTENCY_TTL
+		if key == "" && opts.DeriveKey != nil {
+			// A derived key already identifies the request, so the body
+			// does not have to match byte for byte
+			key = opts.DeriveKey(bodyBytes)
+			requestHash = key
+			ttl = opts.DerivedTTL
+		}
+
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		now := time.Now()
+		record := IdempotencyRecord{
+			Scope:          opts.Scope(c),
+			IdempotencyKey: key,
+			RequestHash:    requestHash,
+			Status:         IDEMPOTENCY_STATUS_IN_PROGRESS,
+			ExpiresAt:      now.Add(ttl),
+		}
+
+		existing, err := claimIdempotencyKey(&record, now)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, APIResponse[any]{
+				Status:  "error",
+				Message: "idempotency_check_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if existing != nil {
+			replayIdempotentResponse(c, existing, requestHash)
+			return
+		}
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		status := writer.Status()
+		if status < 200 || status >= 300 {
+			DB.Delete(&IdempotencyRecord{}, record.ID)
+			return
+		}
+
+		DB.Model(&IdempotencyRecord{}).Where("id = ?", record.ID).Updates(map[string]any{
+			"status":        IDEMPOTENCY_STATUS_COMPLETED,
+			"status_code":   status,
+			"response_body": writer.body.String(),
+		})
+	}
+}
+
+// claimIdempotencyKey inserts the record unless the key is already taken,
+// in which case the stored record is returned. Expired records are discarded
+// and the claim retried.
+func claimIdempotencyKey(record *IdempotencyRecord, now time.Time) (*IdempotencyRecord, error) {
+	for {
+		result := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return nil, nil
+		}
+
+		var existing IdempotencyRecord
+		if err := DB.Where("scope = ? AND idempotency_key = ?", record.Scope, record.IdempotencyKey).First(&existing).Error; err != nil {
+			return nil, err
+		}
+
+		if existing.ExpiresAt.After(now) {
+			return &existing, nil
+		}
+
+		if err := DB.Where("id = ? AND expires_at <= ?", existing.ID, now).Delete(&IdempotencyRecord{}).Error; err != nil {
+			return nil, err
+		}
+		record.ID = 0
+	}
+}
+
+func replayIdempotentResponse(c *gin.Context, record *IdempotencyRecord, requestHash string) {
+	if record.RequestHash != requestHash {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, APIResponse[any]{
+			Status:  "error",
+			Message: "idempotency_key_reused",
+			Error:   "Idempotency-Key was already used with a different request body",
+			Data:    nil,
+		})
+		return
+	}
+
+	if record.Status != IDEMPOTENCY_STATUS_COMPLETED {
+		c.AbortWithStatusJSON(http.StatusConflict, APIResponse[any]{
+			Status:  "error",
+			Message: "request_in_progress",
+			Error:   "a request with this Idempotency-Key is still being processed",
+			Data:    nil,
+		})
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(record.StatusCode, "application/json; charset=utf-8", []byte(record.ResponseBody))
+	c.Abort()
+}
+
+// purgeExpiredIdempotencyRecords drops keys past their TTL.
+func purgeExpiredIdempotencyRecords(now time.Time) error {
+	return DB.Where("expires_at <= ?", now).Delete(&IdempotencyRecord{}).Error
+}
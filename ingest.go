@@ -1,10 +1,19 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
 	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIngestNotPending = errors.New("ingest is not pending")
+	ErrInvalidPassword  = errors.New("invalid password")
 )
 
 type TaskRequest struct {
@@ -13,25 +22,57 @@ type TaskRequest struct {
 	Url    string `json:"url"`
 }
 
-func Ingest(req TaskRequest) error {
+// Ingest records a task announced by a quiz source. source may be nil when
+// no source is configured.
+func Ingest(req TaskRequest, source *QuizSource) (*Ingests, error) {
 	var ingest Ingests
 	now := time.Now()
 
 	reqJSON, _ := json.Marshal(req)
 
+	secret, keyID, err := sealSecret(req.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+
 	ingest.Email = req.Email
-	ingest.Secret = req.Secret
+	ingest.Secret = secret
+	ingest.SecretKeyID = keyID
 	ingest.URL = req.Url
 	ingest.Status = IngestStatusPending
+	ingest.StatusChangedAt = now
 	ingest.CreatedAt = now
-	ingest.Raw = string(reqJSON)
-	ingest.Deadline = now.Add(3 * time.Minute)
+	ingest.Raw = redactRawRequest(reqJSON)
+	ingest.Deadline = now.Add(AppConfig.QuizWindow)
+	if source != nil {
+		ingest.QuizSourceID = &source.ID
+	}
 
-	if err := DB.Create(&ingest).Error; err != nil {
-		return err
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&ingest).Error; err != nil {
+			return err
+		}
+
+		return recordStatusHistory(tx, STATUS_ENTITY_INGEST, ingest.ID, "", string(IngestStatusPending), "created")
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	Events.Publish(EVENT_INGEST_CREATED, ingest)
+	return &ingest, nil
+}
+
+// DeriveIngestIdempotencyKey keys an ingest request by email and URL, so an
+// upstream retry of the same task does not create a duplicate ingest.
+func DeriveIngestIdempotencyKey(body []byte) string {
+	var req TaskRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.Email == "" || req.Url == "" {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(req.Email + "\n" + req.Url))
+	return "derived:" + hex.EncodeToString(hash[:])
 }
 
 func ListIngests() ([]Ingests, error) {
@@ -44,22 +85,48 @@ func ListIngests() ([]Ingests, error) {
 	return ingests, nil
 }
 
-func AcceptIngest(id uint, password string) error {
-	if password != os.Getenv("INGEST_ACCEPT_PASSWORD") {
-		return fmt.Errorf("invalid password")
+// AcceptIngest accepts an ingest on behalf of a dashboard operator.
+func AcceptIngest(id uint, password string, audit AuditContext) (err error) {
+	defer func() { recordAuditEvent(audit, AUDIT_ACTION_ACCEPT_INGEST, AuditTargets{IngestID: &id}, err) }()
+
+	if !secretsEqual(password, AppConfig.IngestAcceptPassword) {
+		return ErrInvalidPassword
 	}
 
-	var ingest Ingests
+	return acceptIngest(id, audit.Actor)
+}
 
+// acceptIngest marks an ingest as accepted without checking any password.
+// Callers are responsible for having authenticated the responder. operator
+// is empty when the acceptance did not come from the dashboard.
+func acceptIngest(id uint, operator string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := TransitionIngest(tx, id, IngestStatusNotified, "accepted"); err != nil {
+			return err
+		}
+
+		return tx.Model(&Ingests{}).Where("id = ?", id).Update("accepted_by", operator).Error
+	})
+}
+
+// ActivateIngest accepts a pending ingest and starts its quiz session in one
+// step. It is used by escalation paths where the responder has already been
+// verified, and returns ErrIngestNotPending if someone else got there first.
+func ActivateIngest(id uint, audit AuditContext) (err error) {
+	defer func() { recordAuditEvent(audit, AUDIT_ACTION_ACTIVATE_INGEST, AuditTargets{IngestID: &id}, err) }()
+
+	var ingest Ingests
 	if err := DB.First(&ingest, id).Error; err != nil {
 		return err
 	}
 
-	ingest.Status = IngestStatusNotified
+	if ingest.Status != IngestStatusPending {
+		return ErrIngestNotPending
+	}
 
-	if err := DB.Save(&ingest).Error; err != nil {
+	if err := acceptIngest(id, ""); err != nil {
 		return err
 	}
 
-	return nil
+	return StartQuizSession(ingest.ID)
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	STATUS_ENTITY_INGEST       = "ingest"
+	STATUS_ENTITY_QUIZ_SESSION = "quiz_session"
+)
+
+// Allowed ingest transitions. Completed and Failed are terminal.
+var ingestTransitions = map[IngestStatus][]IngestStatus{
+	IngestStatusPending:  {IngestStatusNotified, IngestStatusRunning, IngestStatusFailed},
+	IngestStatusNotified: {IngestStatusRunning, IngestStatusFailed},
+	IngestStatusRunning:  {IngestStatusCompleted, IngestStatusFailed},
+}
+
+// Allowed quiz session transitions. Completed and Failed are terminal.
+var sessionTransitions = map[QuizSessionStatus][]QuizSessionStatus{
+	QuizSessionStatusWaiting: {QuizSessionStatusRunning, QuizSessionStatusCompleted, QuizSessionStatusFailed},
+	QuizSessionStatusRunning: {QuizSessionStatusWaiting, QuizSessionStatusCompleted, QuizSessionStatusFailed},
+}
+
+// TransitionError is returned when a status change is not allowed from the
+// record's current state, or the state changed underneath the caller.
+type TransitionError struct {
+	Entity string
+	ID     uint
+	From   string
+	To     string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s %d cannot transition from %q to %q", e.Entity, e.ID, e.From, e.To)
+}
+
+func canTransition[S comparable](transitions map[S][]S, from, to S) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionIngest moves an ingest to a new status if the lifecycle allows
+// it. A reason is recorded in the history and, for failures, on the ingest.
+func TransitionIngest(tx *gorm.DB, id uint, to IngestStatus, reason string) error {
+	var ingest Ingests
+	if err := tx.First(&ingest, id).Error; err != nil {
+		return err
+	}
+
+	return transitionIngest(tx, &ingest, to, reason)
+}
+
+func transitionIngest(tx *gorm.DB, ingest *Ingests, to IngestStatus, reason string) error {
+	from := ingest.Status
+	rejected := &TransitionError{Entity: STATUS_ENTITY_INGEST, ID: ingest.ID, From: string(from), To: string(to)}
+
+	if !canTransition(ingestTransitions, from, to) {
+		return rejected
+	}
+
+	now := time.Now()
+	updates := map[string]any{
+		"status":            to,
+		"status_changed_at": now,
+	}
+	if to == IngestStatusFailed {
+		updates["failure_reason"] = reason
+	}
+
+	// Conditional on the old status so concurrent transitions cannot both win
+	result := tx.Model(&Ingests{}).Where("id = ? AND status = ?", ingest.ID, from).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return rejected
+	}
+
+	ingest.Status = to
+	ingest.StatusChangedAt = now
+
+	return recordStatusHistory(tx, STATUS_ENTITY_INGEST, ingest.ID, string(from), string(to), reason)
+}
+
+// TransitionSession moves a quiz session to a new status if the lifecycle
+// allows it. A reason is recorded in the history and, for failures, on the
+// session.
+func TransitionSession(tx *gorm.DB, id uint, to QuizSessionStatus, reason string) error {
+	var session QuizSession
+	if err := tx.First(&session, id).Error; err != nil {
+		return err
+	}
+
+	return transitionSession(tx, &session, to, reason)
+}
+
+func transitionSession(tx *gorm.DB, session *QuizSession, to QuizSessionStatus, reason string) error {
+	from := session.Status
+	rejected := &TransitionError{Entity: STATUS_ENTITY_QUIZ_SESSION, ID: session.ID, From: string(from), To: string(to)}
+
+	if !canTransition(sessionTransitions, from, to) {
+		return rejected
+	}
+
+	now := time.Now()
+	updates := map[string]any{
+		"status":            to,
+		"status_changed_at": now,
+	}
+	if to == QuizSessionStatusFailed {
+		updates["failure_reason"] = reason
+	}
+
+	result := tx.Model(&QuizSession{}).Where("id = ? AND status = ?", session.ID, from).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return rejected
+	}
+
+	session.Status = to
+	session.StatusChangedAt = now
+
+	return recordStatusHistory(tx, STATUS_ENTITY_QUIZ_SESSION, session.ID, string(from), string(to), reason)
+}
+
+func recordStatusHistory(tx *gorm.DB, entity string, id uint, from, to, reason string) error {
+	return tx.Create(&StatusHistory{
+		EntityType: entity,
+		EntityID:   id,
+		FromStatus: from,
+		ToStatus:   to,
+		Reason:     reason,
+	}).Error
+}
+
+// GetStatusHistory returns the status changes of an entity, oldest first.
+func GetStatusHistory(entity string, id uint) ([]StatusHistory, error) {
+	var history []StatusHistory
+	err := DB.Where("entity_type = ? AND entity_id = ?", entity, id).
+		Order("created_at ASC, id ASC").
+		Find(&history).Error
+	return history, err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Failed guesses allowed before a subject is locked out
+	LOCKOUT_THRESHOLD = 5
+	// The first lockout lasts this long, doubling with each further failure
+	LOCKOUT_BASE_DURATION = 30 * time.Second
+	LOCKOUT_MAX_DURATION  = time.Hour
+	// A counter with no failures for this long starts over
+	LOCKOUT_RESET_AFTER = time.Hour
+)
+
+type LockoutOptions struct {
+	// Scope names what is being guessed, e.g. "login" or "accept"
+	Scope string
+	// Target picks the account or record under attack from the request,
+	// e.g. the username. Returning "" limits the request by IP only.
+	Target func(c *gin.Context, body []byte) string
+}
+
+// LimitFailedAttempts counts failed guesses per client IP and per target,
+// and locks a subject out for exponentially longer once it passes
+// LOCKOUT_THRESHOLD. Any 401 or 403 from the handlers after it counts as a
+// failure, so it must run after EnsureAuthenticated and RequirePermission.
+// IPs are counted per scope, so failures on one route never lock another.
+// A success clears the target's counter; the IP counter only expires.
+func LimitFailedAttempts(opts LockoutOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bodyBytes, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, APIResponse[any]{
+				Status:  "error",
+				Message: "could_not_read_request_body",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+		subjects := []string{"ip:" + opts.Scope + ":" + c.ClientIP()}
+		var target string
+		if opts.Target != nil {
+			if t := opts.Target(c, bodyBytes); t != "" {
+				target = opts.Scope + ":" + t
+				subjects = append(subjects, target)
+			}
+		}
+
+		now := time.Now()
+		lockedUntil, err := lockedOutUntil(subjects, now)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, APIResponse[any]{
+				Status:  "error",
+				Message: "lockout_check_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if lockedUntil.After(now) {
+			retryAfter := int(lockedUntil.Sub(now).Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, APIResponse[any]{
+				Status:  "error",
+				Message: "too_many_failed_attempts",
+				Error:   fmt.Sprintf("locked out after repeated failures, retry in %ds", retryAfter),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.Next()
+
+		switch status := c.Writer.Status(); {
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			for _, subject := range subjects {
+				if err := recordFailedAttempt(subject, time.Now()); err != nil {
+					c.Error(fmt.Errorf("failed to record failed attempt for %s: %v", subject, err))
+				}
+			}
+		case status >= 200 && status < 300 && target != "":
+			DB.Where("subject = ?", target).Delete(&FailedAttemptCounter{})
+		}
+	}
+}
+
+// jsonBodyField returns a top-level field of a JSON body as text, for use
+// as a lockout target.
+func jsonBodyField(field string) func(c *gin.Context, body []byte) string {
+	return func(c *gin.Context, body []byte) string {
+		var fields map[string]any
+		if err := json.Unmarshal(body, &fields); err != nil || fields[field] == nil {
+			return ""
+		}
+		return fmt.Sprint(fields[field])
+	}
+}
+
+// lockedOutUntil returns the latest lockout among subjects, or the zero
+// time when none is locked.
+func lockedOutUntil(subjects []string, now time.Time) (time.Time, error) {
+	var counters []FailedAttemptCounter
+	if err := DB.Where("subject IN ? AND locked_until > ?", subjects, now).Find(&counters).Error; err != nil {
+		return time.Time{}, err
+	}
+
+	var until time.Time
+	for _, counter := range counters {
+		if counter.LockedUntil.After(until) {
+			until = counter.LockedUntil
+		}
+	}
+	return until, nil
+}
+
+func recordFailedAttempt(subject string, now time.Time) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&FailedAttemptCounter{Subject: subject}).Error; err != nil {
+			return err
+		}
+
+		var counter FailedAttemptCounter
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("subject = ?", subject).First(&counter).Error; err != nil {
+			return err
+		}
+
+		if now.Sub(counter.LastFailureAt) > LOCKOUT_RESET_AFTER {
+			counter.Failures = 0
+		}
+		counter.Failures++
+		counter.LastFailureAt = now
+		if counter.Failures >= LOCKOUT_THRESHOLD {
+			counter.LockedUntil = now.Add(lockoutDuration(counter.Failures))
+		}
+
+		return tx.Save(&counter).Error
+	})
+}
+
+// lockoutDuration doubles from LOCKOUT_BASE_DURATION for every failure past
+// the threshold, up to LOCKOUT_MAX_DURATION.
+func lockoutDuration(failures int) time.Duration {
+	duration := LOCKOUT_BASE_DURATION
+	for i := LOCKOUT_THRESHOLD; i < failures && duration < LOCKOUT_MAX_DURATION; i++ {
+		duration *= 2
+	}
+	return min(duration, LOCKOUT_MAX_DURATION)
+}
+
+// purgeStaleFailedAttempts drops counters that are no longer locked and
+// would start over at the next failure anyway.
+func purgeStaleFailedAttempts(now time.Time) error {
+	return DB.Where("last_failure_at <= ? AND locked_until <= ?", now.Add(-LOCKOUT_RESET_AFTER), now).
+		Delete(&FailedAttemptCounter{}).Error
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func findCounter(t *testing.T, subject string) *FailedAttemptCounter {
+	t.Helper()
+
+	var counters []FailedAttemptCounter
+	if err := DB.Where("subject = ?", subject).Find(&counters).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(counters) == 0 {
+		return nil
+	}
+	return &counters[0]
+}
+
+func TestAcceptLockout(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", "https://example.com/q1")).expect(t, 200, "ingest_created")
+
+	for i := 0; i < LOCKOUT_THRESHOLD; i++ {
+		doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, "wrong")).expect(t, 401, "invalid_password")
+	}
+
+	// Locked out, even with the right password
+	res := doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword)).
+		expect(t, 429, "too_many_failed_attempts")
+	if res.Header.Get("Retry-After") == "" {
+		t.Errorf("lockout response has no Retry-After header")
+	}
+	if ingest := mustFindIngest(t, 1); ingest.Status != IngestStatusPending {
+		t.Fatalf("locked out request moved ingest to %q", ingest.Status)
+	}
+
+	// X-Forwarded-For is ignored unless the proxy is trusted
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword), "X-Forwarded-For", "203.0.113.9").
+		expect(t, 429, "too_many_failed_attempts")
+
+	if err := DB.Model(&FailedAttemptCounter{}).Where("1 = 1").Update("locked_until", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword)).
+		expect(t, 200, "ingest_accepted_and_quiz_started")
+
+	// Success clears the target, but the IP keeps its history
+	if counter := findCounter(t, "accept:1"); counter != nil {
+		t.Errorf("accept:1 counter survived a success: %+v", counter)
+	}
+	if counter := findCounter(t, "ip:accept:192.0.2.1"); counter == nil || counter.Failures != LOCKOUT_THRESHOLD {
+		t.Fatalf("unexpected IP counter %+v", counter)
+	}
+
+	// Other routes count the IP on their own
+	doRequest(t, r, "POST", "/quiz/sessions/1/answer", map[string]any{
+		"password":  "wrong",
+		"submitUrl": "https://example.com/submit",
+		"answer":    1,
+	}).expect(t, 403, "invalid_password")
+	if counter := findCounter(t, "ip:answer:192.0.2.1"); counter == nil || counter.Failures != 1 || counter.LockedUntil.After(time.Now()) {
+		t.Errorf("unexpected answer IP counter %+v", counter)
+	}
+
+	// The next failure on accept locks the IP out for twice as long
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, "wrong")).expect(t, 401, "invalid_password")
+
+	counter := findCounter(t, "ip:accept:192.0.2.1")
+	if lockout := time.Until(counter.LockedUntil); lockout < LOCKOUT_BASE_DURATION || lockout > 2*LOCKOUT_BASE_DURATION {
+		t.Errorf("second lockout lasts %v, want about %v", lockout, 2*LOCKOUT_BASE_DURATION)
+	}
+	doRequest(t, r, "GET", "/ingest", nil).expect(t, 200, "ingests_listed")
+}
+
+func TestTokenAcceptLockout(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	AppConfig.AcceptTokenSecret = "token-secret"
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", "https://example.com/q1")).expect(t, 200, "ingest_created")
+	token, err := IssueAcceptToken(1, mustFindIngest(t, 1).Deadline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doRequest(t, r, "POST", "/ingest/token-accept", map[string]any{"token": token + "x"}).expect(t, 403, "invalid_accept_token")
+	doRequest(t, r, "POST", "/ingest/token-accept", map[string]any{"token": token}).expect(t, 200, "ingest_accepted_and_quiz_started")
+
+	// A second tap on the same notification is not a guess
+	for i := 0; i < LOCKOUT_THRESHOLD; i++ {
+		doRequest(t, r, "POST", "/ingest/token-accept", map[string]any{"token": token}).expect(t, 409, "accept_token_used")
+	}
+	if counter := findCounter(t, "ip:token-accept:192.0.2.1"); counter == nil || counter.Failures != 1 {
+		t.Errorf("unexpected token-accept IP counter %+v", counter)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	r := newTestRouter(t)
+
+	for i := 0; i < LOCKOUT_THRESHOLD; i++ {
+		doRequest(t, r, "POST", "/auth/login", map[string]any{"username": testOperator, "password": "wrong-password"}).
+			expect(t, 401, "invalid_credentials")
+	}
+
+	doRequest(t, r, "POST", "/auth/login", map[string]any{"username": testOperator, "password": testOperatorPass}).
+		expect(t, 429, "too_many_failed_attempts")
+
+	if counter := findCounter(t, "login:"+testOperator); counter == nil || counter.Failures != LOCKOUT_THRESHOLD {
+		t.Errorf("unexpected login counter %+v", counter)
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		LOCKOUT_THRESHOLD:      LOCKOUT_BASE_DURATION,
+		LOCKOUT_THRESHOLD + 1:  2 * LOCKOUT_BASE_DURATION,
+		LOCKOUT_THRESHOLD + 3:  8 * LOCKOUT_BASE_DURATION,
+		LOCKOUT_THRESHOLD + 50: LOCKOUT_MAX_DURATION,
+	} {
+		if got := lockoutDuration(failures); got != want {
+			t.Errorf("lockoutDuration(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestStaleFailedAttemptsArePurged(t *testing.T) {
+	newTestRouter(t)
+
+	past := time.Now().Add(-2 * LOCKOUT_RESET_AFTER)
+	if err := recordFailedAttempt("ip:login:198.51.100.1", past); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordFailedAttempt("ip:login:198.51.100.2", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := purgeStaleFailedAttempts(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if findCounter(t, "ip:login:198.51.100.1") != nil || findCounter(t, "ip:login:198.51.100.2") == nil {
+		t.Errorf("purge removed the wrong counters")
+	}
+}
+
+func TestTokenAcceptReleasedWhenActivationFails(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	AppConfig.AcceptTokenSecret = "token-secret"
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", "https://example.com/q1")).expect(t, 200, "ingest_created")
+	token, err := IssueAcceptToken(1, mustFindIngest(t, 1).Deadline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failIngestUpdates := func(tx *gorm.DB) {
+		if tx.Statement.Table == "ingests" {
+			tx.AddError(errors.New("ingest update failed"))
+		}
+	}
+	if err := DB.Callback().Update().Before("gorm:update").Register("test:fail_ingest_updates", failIngestUpdates); err != nil {
+		t.Fatal(err)
+	}
+	doRequest(t, r, "POST", "/ingest/token-accept", map[string]any{"token": token}).expect(t, 500, "accept_ingest_failed")
+	DB.Callback().Update().Remove("test:fail_ingest_updates")
+
+	// The failed accept did not use up the notification's token
+	doRequest(t, r, "POST", "/ingest/token-accept", map[string]any{"token": token}).expect(t, 200, "ingest_accepted_and_quiz_started")
+	doRequest(t, r, "POST", "/ingest/token-accept", map[string]any{"token": token}).expect(t, 409, "accept_token_used")
+}
+
+func TestAnswerLockoutPerOperator(t *testing.T) {
+	r := newTestRouter(t)
+	grader := newTestGrader(t, MockGraderOptions{})
+	session := createRunningSession(t, asOperator(t, r), grader, "a@example.com")
+
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := SaveOperator(name, testOperatorPass, OperatorRoleSolver); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alice := login(t, r, "alice", testOperatorPass)
+	bob := login(t, r, "bob", testOperatorPass)
+
+	body := map[string]any{
+		"password":  "wrong",
+		"submitUrl": grader.SubmitURL(),
+		"answer":    AnswerSubmission{Email: session.Email, Secret: testSecret, URL: grader.QuestionURL(0), Answer: 13},
+	}
+	doRequest(t, r, "POST", "/quiz/sessions/1/answer", body, alice...).expect(t, 403, "invalid_password")
+	doRequest(t, r, "POST", "/quiz/sessions/1/answer", body, bob...).expect(t, 403, "invalid_password")
+
+	body["password"] = testAttemptPass
+	doRequest(t, r, "POST", "/quiz/sessions/1/answer", body, bob...).expect(t, 200, "answer_submitted")
+
+	// Bob's success does not clear Alice's failures on the same session
+	if counter := findCounter(t, "answer:alice:1"); counter == nil || counter.Failures != 1 {
+		t.Errorf("unexpected counter for alice %+v", counter)
+	}
+	if counter := findCounter(t, "answer:bob:1"); counter != nil {
+		t.Errorf("answer:bob:1 counter survived a success: %+v", counter)
+	}
+}
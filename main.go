@@ -1,7 +1,8 @@
 package main
 
 import (
-	"fmt"
+	"errors"
+	"io/fs"
 	"log"
 	"os"
 	"time"
@@ -11,340 +12,82 @@ import (
 )
 
 func main() {
-	err := InitDB()
-	if err != nil {
-		log.Fatalf("Error initializing database: %v", err)
+	// .env is optional; settings can also come from CONFIG_FILE or the environment
+	err := godotenv.Load(".env")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	err = godotenv.Load(".env")
+	cfg, err := LoadConfig()
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
+	AppConfig = cfg
 
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
-
-	/* Frontend */
-	r.Static("/assets", "./public/assets")
-	r.GET("/quiz-interface", func(c *gin.Context) {
-		c.File("./public/index.html")
-	})
-	r.NoRoute(func(c *gin.Context) {
-		c.File("./public/index.html")
-	})
-
-	ingestGroup := r.Group("/ingest")
-	ingestGroup.Use(EnsureAuthenticated())
-	ingestGroup.POST("", func(c *gin.Context) {
-		var req TaskRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(400, APIResponse[any]{
-				Status:  "error",
-				Message: "invalid_request_body",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		err := Ingest(req)
-		if err != nil {
-			c.JSON(500, APIResponse[any]{
-				Status:  "error",
-				Message: "ingest_failed",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		c.JSON(200, APIResponse[any]{
-			Status:  "success",
-			Message: "ingest_created",
-			Error:   "",
-			Data:    nil,
-		})
-	})
-
-	ingestGroup.GET("", func(c *gin.Context) {
-		ingests, err := ListIngests()
-		if err != nil {
-			c.JSON(500, APIResponse[any]{
-				Status:  "error",
-				Message: "list_ingests_failed",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		c.JSON(200, APIResponse[[]Ingests]{
-			Status:  "success",
-			Message: "ingests_listed",
-			Error:   "",
-			Data:    ingests,
-		})
-	})
-
-	ingestGroup.GET("/notification-accept", func(c *gin.Context) {
-		idParam := c.Query("id")
-		password := c.Query("password")
-
-		var id uint
-		_, err := fmt.Sscanf(idParam, "%d", &id)
-		if err != nil {
-			c.JSON(400, APIResponse[any]{
-				Status:  "error",
-				Message: "invalid_id_parameter",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		err = AcceptIngest(id, password)
-		if err != nil {
-			c.JSON(500, APIResponse[any]{
-				Status:  "error",
-				Message: "accept_ingest_failed",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		// Get the accepted ingest to start the quiz session
-		var ingest Ingests
-		if err := DB.First(&ingest, id).Error; err != nil {
-			c.JSON(500, APIResponse[any]{
-				Status:  "error",
-				Message: "ingest_not_found",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		// Start the quiz session now that it's accepted
-		req := TaskRequest{
-			Email:  ingest.Email,
-			Secret: ingest.Secret,
-			Url:    ingest.URL,
-		}
-
-		err = StartQuizSession(req)
-		if err != nil {
-			c.JSON(500, APIResponse[any]{
-				Status:  "error",
-				Message: "quiz_session_failed",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		c.JSON(200, APIResponse[any]{
-			Status:  "success",
-			Message: "ingest_accepted_and_quiz_started",
-			Error:   "",
-			Data:    nil,
-		})
-	})
-
-	// Quiz management endpoints
-	quizGroup := r.Group("/quiz")
-	quizGroup.Use(EnsureAuthenticated())
-
-	// Get all quiz sessions
-	quizGroup.GET("/sessions", func(c *gin.Context) {
-		sessions, err := GetQuizSessions()
-		if err != nil {
-			c.JSON(500, APIResponse[any]{
-				Status:  "error",
-				Message: "failed_to_get_sessions",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		c.JSON(200, APIResponse[[]QuizSession]{
-			Status:  "success",
-			Message: "sessions_retrieved",
-			Error:   "",
-			Data:    sessions,
-		})
-	})
-
-	// Get attempts for a specific session
-	quizGroup.GET("/sessions/:id/attempts", func(c *gin.Context) {
-		var sessionID uint
-		_, err := fmt.Sscanf(c.Param("id"), "%d", &sessionID)
-		if err != nil {
-			c.JSON(400, APIResponse[any]{
-				Status:  "error",
-				Message: "invalid_session_id",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		attempts, err := GetQuizAttempts(sessionID)
-		if err != nil {
-			c.JSON(500, APIResponse[any]{
-				Status:  "error",
-				Message: "failed_to_get_attempts",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		c.JSON(200, APIResponse[[]QuizAttempt]{
-			Status:  "success",
-			Message: "attempts_retrieved",
-			Error:   "",
-			Data:    attempts,
-		})
-	})
-
-	// Get pending attempts that need answers
-	quizGroup.GET("/pending", func(c *gin.Context) {
-		attempts, err := GetPendingAttempts()
-		if err != nil {
-			c.JSON(500, APIResponse[any]{
-				Status:  "error",
-				Message: "failed_to_get_pending_attempts",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
-
-		c.JSON(200, APIResponse[[]QuizAttempt]{
-			Status:  "success",
-			Message: "pending_attempts_retrieved",
-			Error:   "",
-			Data:    attempts,
-		})
-	})
-
-	// Submit answer for a specific session
-	quizGroup.POST("/sessions/:id/answer", func(c *gin.Context) {
-		var sessionID uint
-		_, err := fmt.Sscanf(c.Param("id"), "%d", &sessionID)
-		if err != nil {
-			c.JSON(400, APIResponse[any]{
-				Status:  "error",
-				Message: "invalid_session_id",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := RunMigrateCommand(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Error running migrations: %v", err)
 		}
+		return
+	}
 
-		var answerReq struct {
-			Answer    interface{} `json:"answer"`
-			SubmitURL string      `json:"submitUrl,omitempty"`
-			Password  string      `json:"password"`
+	if len(os.Args) > 1 && os.Args[1] == "quiz-source" {
+		if err := RunQuizSourceCommand(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Error managing quiz sources: %v", err)
 		}
+		return
+	}
 
-		if err := c.ShouldBindJSON(&answerReq); err != nil {
-			c.JSON(400, APIResponse[any]{
-				Status:  "error",
-				Message: "invalid_answer_format",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
+	if len(os.Args) > 1 && os.Args[1] == "operator" {
+		if err := RunOperatorCommand(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Error managing operators: %v", err)
 		}
+		return
+	}
 
-		var response *QuizResponse
-		if answerReq.SubmitURL == "" {
-			c.JSON(400, APIResponse[any]{
-				Status:  "error",
-				Message: "submit_url_required",
-				Error:   "submitUrl is required",
-				Data:    nil,
-			})
-			return
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		if err := RunRotateKeysCommand(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Error rotating encryption keys: %v", err)
 		}
+		return
+	}
 
-		// Validate password
-		expectedPassword := os.Getenv("QUIZ_ATTEMPT_PASSWORD")
-		if answerReq.Password != expectedPassword {
-			c.JSON(403, APIResponse[any]{
-				Status:  "error",
-				Message: "invalid_password",
-				Error:   "Invalid quiz attempt password",
-				Data:    nil,
-			})
-			return
+	if len(os.Args) > 1 && os.Args[1] == "mock-grader" {
+		if err := RunMockGraderCommand(os.Args[2:]); err != nil {
+			log.Fatalf("Error running mock grader: %v", err)
 		}
+		return
+	}
 
-		response, err = SubmitManualAnswer(sessionID, answerReq.Answer, answerReq.SubmitURL)
-
-		if err != nil {
-			c.JSON(500, APIResponse[any]{
-				Status:  "error",
-				Message: "failed_to_submit_answer",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
 
-		c.JSON(200, APIResponse[*QuizResponse]{
-			Status:  "success",
-			Message: "answer_submitted",
-			Error:   "",
-			Data:    response,
-		})
-	})
+	err = InitDB(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Error initializing database: %v", err)
+	}
 
-	// Initial submission endpoint
-	r.POST("/submit-initial", func(c *gin.Context) {
-		type InitialSubmissionBody struct {
-			Email  string `json:"email" binding:"required"`
-			Secret string `json:"secret" binding:"required"`
-			Answer string `json:"answer" binding:"required"`
-		}
+	err = EnsureDefaultQuizSource(cfg)
+	if err != nil {
+		log.Fatalf("Error seeding quiz source: %v", err)
+	}
 
-		var body InitialSubmissionBody
-		if err := c.ShouldBindJSON(&body); err != nil {
-			c.JSON(400, APIResponse[any]{
-				Status:  "error",
-				Message: "invalid_request_body",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
+	if operators, err := ListOperators(); err == nil && len(operators) == 0 {
+		log.Printf("No operators exist; create one with `operator set <username>` to use the dashboard")
+	}
 
-		response, err := ProcessInitialSubmissionFlow(body.Email, body.Secret, body.Answer)
-		if err != nil {
-			c.JSON(500, APIResponse[any]{
-				Status:  "error",
-				Message: "initial_submission_failed",
-				Error:   err.Error(),
-				Data:    nil,
-			})
-			return
-		}
+	if count, err := CountStaleSecrets(); err == nil && count > 0 {
+		log.Printf("%d stored secrets are not under the current encryption key; run `rotate-keys` to re-encrypt them", count)
+	}
 
-		message := "initial_submission_completed"
-		if response.Correct && response.URL != "" {
-			message = "initial_submission_completed_with_ingest_and_quiz_session"
-		}
+	err = InitNotifiers(cfg)
+	if err != nil {
+		log.Fatalf("Error initializing notifiers: %v", err)
+	}
 
-		c.JSON(200, APIResponse[*InitialSubmissionResponse]{
-			Status:  "success",
-			Message: message,
-			Error:   "",
-			Data:    response,
-		})
-	})
+	gin.SetMode(gin.ReleaseMode)
+	r := NewRouter(cfg)
 
 	go func() {
 		for {
@@ -353,6 +96,13 @@ func main() {
 		}
 	}()
 
-	log.Printf("Starting server on :%s", os.Getenv("PORT"))
-	r.Run(":" + os.Getenv("PORT"))
+	go func() {
+		for {
+			SweepJob()
+			time.Sleep(SWEEP_INTERVAL)
+		}
+	}()
+
+	log.Printf("Starting server on :%s", cfg.Port)
+	r.Run(":" + cfg.Port)
 }
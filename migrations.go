@@ -0,0 +1,776 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one versioned schema change. Migrations run in version order,
+// each in its own transaction together with its schema_migrations row.
+//
+// Migrations must not use the live models in db.go, which keep changing.
+// Each one declares the table shapes it works with, as of when it was written.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration.
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// MigrationState pairs a known migration with when it was applied, if ever.
+type MigrationState struct {
+	Migration Migration
+	AppliedAt *time.Time
+}
+
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_initial_schema",
+		// Databases created by the old AutoMigrate setup already have these
+		// tables, so this converges to the schema instead of creating blindly
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ingestsV1{}, &quizSessionV1{}, &quizAttemptV1{}, &notificationLedgerV1{}, &acceptTokenV1{}, &statusHistoryV1{}, &idempotencyRecordV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&idempotencyRecordV1{}, &statusHistoryV1{}, &acceptTokenV1{}, &notificationLedgerV1{}, &quizAttemptV1{}, &quizSessionV1{}, &ingestsV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "backfill_attempt_deadlines",
+		// Set a deadline 3 minutes from creation for attempts that don't have one
+		Up: func(tx *gorm.DB) error {
+			var attempts []quizAttemptV1
+			if err := tx.Where("deadline IS NULL OR deadline = ?", time.Time{}).Find(&attempts).Error; err != nil {
+				return err
+			}
+
+			for _, attempt := range attempts {
+				if err := tx.Model(&quizAttemptV1{}).Where("id = ?", attempt.ID).
+					Update("deadline", attempt.CreatedAt.Add(3*time.Minute)).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error { return nil },
+	},
+	{
+		Version: 3,
+		Name:    "link_sessions_to_ingests",
+		Up:      backfillSessionIngestIDs,
+		Down:    func(tx *gorm.DB) error { return nil },
+	},
+	{
+		Version: 4,
+		Name:    "add_session_attempt_foreign_keys",
+		// Runs after the backfill so existing sessions point at real ingests
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasConstraint(&quizSessionV4{}, "Ingest") {
+				if err := tx.Migrator().CreateConstraint(&quizSessionV4{}, "Ingest"); err != nil {
+					return err
+				}
+			}
+			if !tx.Migrator().HasConstraint(&quizAttemptV4{}, "Session") {
+				if err := tx.Migrator().CreateConstraint(&quizAttemptV4{}, "Session"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropConstraint(&quizAttemptV4{}, "Session"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropConstraint(&quizSessionV4{}, "Ingest")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_quiz_sources",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&quizSourceV5{}); err != nil {
+				return err
+			}
+			if err := tx.Migrator().AddColumn(&ingestsV5{}, "QuizSourceID"); err != nil {
+				return err
+			}
+			return tx.Migrator().CreateConstraint(&ingestsV5{}, "QuizSource")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropConstraint(&ingestsV5{}, "QuizSource"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropColumn(&ingestsV5{}, "QuizSourceID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&quizSourceV5{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_operator_auth",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&operatorV6{}, &operatorSessionV6{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&operatorSessionV6{}, &operatorV6{})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_operator_roles",
+		// Operators created before roles existed had full access
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&operatorV7{}, "Role"); err != nil {
+				return err
+			}
+			if err := tx.Model(&operatorV7{}).Where("1 = 1").Update("role", "admin").Error; err != nil {
+				return err
+			}
+			if err := tx.Migrator().AddColumn(&ingestsV7{}, "AcceptedBy"); err != nil {
+				return err
+			}
+			return tx.Migrator().AddColumn(&quizAttemptV7{}, "SubmittedBy")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&quizAttemptV7{}, "SubmittedBy"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropColumn(&ingestsV7{}, "AcceptedBy"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&operatorV7{}, "Role")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_audit_events",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&auditEventV8{}); err != nil {
+				return err
+			}
+
+			// Make the table append-only in the database itself
+			for _, statement := range auditTriggerStatements[tx.Dialector.Name()] {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, statement := range auditTriggerDropStatements[tx.Dialector.Name()] {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&auditEventV8{})
+		},
+	},
+	{
+		Version: 9,
+		Name:    "encrypt_participant_secrets",
+		// Existing secrets stay readable as plaintext until `rotate-keys`
+		// encrypts them. Redacting Raw cannot be undone by Down.
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&ingestsV9{}, "SecretKeyID"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().AddColumn(&quizSessionV9{}, "SecretKeyID"); err != nil {
+				return err
+			}
+			return redactIngestRaw(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&quizSessionV9{}, "SecretKeyID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&ingestsV9{}, "SecretKeyID")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create_failed_attempt_counters",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&failedAttemptCounterV10{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&failedAttemptCounterV10{})
+		},
+	},
+	{
+		Version: 11,
+		Name:    "index_session_listing",
+		// The session_id index is replaced by one that also serves the
+		// attempt order of GET /quiz/sessions?include=attempts
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateIndex(&quizSessionV11{}, "Status"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().CreateIndex(&quizAttemptV11{}, "Deadline"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().CreateIndex(&quizAttemptV11{}, "idx_quiz_attempts_session_created"); err != nil {
+				return err
+			}
+			if tx.Migrator().HasIndex(&quizAttemptV1{}, "SessionID") {
+				return tx.Migrator().DropIndex(&quizAttemptV1{}, "SessionID")
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateIndex(&quizAttemptV1{}, "SessionID"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropIndex(&quizAttemptV11{}, "idx_quiz_attempts_session_created"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropIndex(&quizAttemptV11{}, "Deadline"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropIndex(&quizSessionV11{}, "Status")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_attempt_question_pages",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"QuestionHTML", "FetchedAt", "FetchError"} {
+				if err := tx.Migrator().AddColumn(&quizAttemptV12{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"FetchError", "FetchedAt", "QuestionHTML"} {
+				if err := tx.Migrator().DropColumn(&quizAttemptV12{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+var auditTriggerStatements = map[string][]string{
+	"sqlite": {
+		`CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+		BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END`,
+		`CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+		BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END`,
+	},
+	"postgres": {
+		`CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+		BEGIN RAISE EXCEPTION 'audit_events is append-only'; END;
+		$$ LANGUAGE plpgsql`,
+		`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+		FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`,
+	},
+}
+
+var auditTriggerDropStatements = map[string][]string{
+	"sqlite": {
+		`DROP TRIGGER IF EXISTS audit_events_no_update`,
+		`DROP TRIGGER IF EXISTS audit_events_no_delete`,
+	},
+	"postgres": {
+		`DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events`,
+		`DROP FUNCTION IF EXISTS audit_events_append_only()`,
+	},
+}
+
+// MigrateUp applies every pending migration up to and including target. A
+// target of 0 applies all of them.
+func MigrateUp(target int) error {
+	return withMigrationConnection(func(conn *gorm.DB) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range sortedMigrations() {
+			if target > 0 && migration.Version > target {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			log.Printf("Applying migration %d_%s", migration.Version, migration.Name)
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := migration.Up(tx); err != nil {
+					return err
+				}
+
+				return tx.Create(&SchemaMigration{
+					Version:   migration.Version,
+					Name:      migration.Name,
+					AppliedAt: time.Now(),
+				}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s failed: %v", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// MigrateDown rolls back the most recently applied migrations, newest first.
+func MigrateDown(steps int) error {
+	return withMigrationConnection(func(conn *gorm.DB) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		known := map[int]Migration{}
+		for _, migration := range migrations {
+			known[migration.Version] = migration
+		}
+
+		var versions []int
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for i := 0; i < steps && i < len(versions); i++ {
+			migration, ok := known[versions[i]]
+			if !ok {
+				return fmt.Errorf("migration %d is applied but unknown to this build", versions[i])
+			}
+
+			log.Printf("Rolling back migration %d_%s", migration.Version, migration.Name)
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := migration.Down(tx); err != nil {
+					return err
+				}
+
+				return tx.Delete(&SchemaMigration{}, migration.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("rollback of %d_%s failed: %v", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetMigrationStatus lists every known migration with its applied time.
+func GetMigrationStatus() ([]MigrationState, error) {
+	if err := DB.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(DB)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []MigrationState
+	for _, migration := range sortedMigrations() {
+		state := MigrationState{Migration: migration}
+		if record, ok := applied[migration.Version]; ok {
+			state.AppliedAt = &record.AppliedAt
+		}
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// Arbitrary key for the PostgreSQL advisory lock held while migrating
+const MIGRATION_LOCK_KEY = 7243019
+
+// withMigrationConnection pins a single connection for the run. On SQLite,
+// constraints are added by rebuilding tables, which has to happen with
+// foreign keys off so existing rows are copied as they are. On PostgreSQL,
+// an advisory lock keeps replicas starting together from migrating twice.
+func withMigrationConnection(fn func(conn *gorm.DB) error) error {
+	return DB.Connection(func(pinned *gorm.DB) error {
+		// Start each statement fresh while staying on the pinned connection
+		conn := pinned.Session(&gorm.Session{NewDB: true})
+
+		switch conn.Dialector.Name() {
+		case "sqlite":
+			if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+				return err
+			}
+			defer conn.Exec("PRAGMA foreign_keys = ON")
+
+		case "postgres":
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", MIGRATION_LOCK_KEY).Error; err != nil {
+				return err
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", MIGRATION_LOCK_KEY)
+		}
+
+		if err := conn.AutoMigrate(&SchemaMigration{}); err != nil {
+			return err
+		}
+
+		return fn(conn)
+	})
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+	var records []SchemaMigration
+	if err := db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[int]SchemaMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+
+	return applied, nil
+}
+
+func sortedMigrations() []Migration {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+// backfillSessionIngestIDs links sessions without an ingest to the latest
+// ingest for the same email and starting URL created before the session.
+func backfillSessionIngestIDs(tx *gorm.DB) error {
+	var sessions []quizSessionV1
+	if err := tx.Where("ingest_id = 0 OR ingest_id IS NULL").Find(&sessions).Error; err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		var first quizAttemptV1
+		if err := tx.Where("session_id = ?", session.ID).Order("id ASC").First(&first).Error; err != nil {
+			log.Printf("Warning: Quiz session ID %d has no attempts and cannot be linked to an ingest", session.ID)
+			continue
+		}
+
+		var ingest ingestsV1
+		if err := tx.Where("email = ? AND url = ? AND created_at <= ?", session.Email, first.URL, session.CreatedAt).
+			Order("id DESC").
+			First(&ingest).Error; err != nil {
+			log.Printf("Warning: No ingest found for quiz session ID %d", session.ID)
+			continue
+		}
+
+		if err := tx.Model(&quizSessionV1{}).Where("id = ?", session.ID).Update("ingest_id", ingest.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redactIngestRaw removes participant secrets from stored announcements.
+func redactIngestRaw(tx *gorm.DB) error {
+	var ingests []ingestsV1
+	if err := tx.Select("id", "raw").Find(&ingests).Error; err != nil {
+		return err
+	}
+
+	for _, ingest := range ingests {
+		redacted := redactRawRequest([]byte(ingest.Raw))
+		if redacted == ingest.Raw {
+			continue
+		}
+		if err := tx.Model(&ingestsV1{}).Where("id = ?", ingest.ID).Update("raw", redacted).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* Table shapes as of version 1 */
+
+type ingestsV1 struct {
+	ID              uint `gorm:"primaryKey;autoIncrement"`
+	Email           string
+	Secret          string
+	URL             string
+	Raw             string
+	Status          string
+	StatusChangedAt time.Time
+	FailureReason   string
+	CreatedAt       time.Time
+	Deadline        time.Time
+}
+
+func (ingestsV1) TableName() string { return "ingests" }
+
+type quizSessionV1 struct {
+	ID              uint `gorm:"primaryKey;autoIncrement"`
+	IngestID        uint `gorm:"not null;index"`
+	Email           string
+	Secret          string
+	CurrentURL      string
+	Status          string
+	StatusChangedAt time.Time
+	FailureReason   string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+func (quizSessionV1) TableName() string { return "quiz_sessions" }
+
+type quizAttemptV1 struct {
+	ID          uint `gorm:"primaryKey;autoIncrement"`
+	SessionID   uint `gorm:"not null;index"`
+	URL         string
+	Question    string
+	Answer      string `gorm:"default:''"`
+	SubmitURL   string
+	Correct     *bool `gorm:"default:null"`
+	NextURL     string
+	Reason      string
+	ResponseRaw string
+	Deadline    time.Time
+	ExpiredAt   *time.Time
+	ClaimedAt   *time.Time
+	CreatedAt   time.Time
+}
+
+func (quizAttemptV1) TableName() string { return "quiz_attempts" }
+
+type notificationLedgerV1 struct {
+	ID         uint   `gorm:"primaryKey;autoIncrement"`
+	IngestID   uint   `gorm:"uniqueIndex:idx_ledger_ingest_channel"`
+	Channel    string `gorm:"uniqueIndex:idx_ledger_ingest_channel"`
+	Attempts   int
+	LastSentAt time.Time
+	NextSendAt time.Time
+	Status     string
+	LastError  string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (notificationLedgerV1) TableName() string { return "notification_ledgers" }
+
+type acceptTokenV1 struct {
+	ID        uint   `gorm:"primaryKey;autoIncrement"`
+	IngestID  uint   `gorm:"index"`
+	Nonce     string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (acceptTokenV1) TableName() string { return "accept_tokens" }
+
+type statusHistoryV1 struct {
+	ID         uint   `gorm:"primaryKey;autoIncrement"`
+	EntityType string `gorm:"index:idx_status_history_entity"`
+	EntityID   uint   `gorm:"index:idx_status_history_entity"`
+	FromStatus string
+	ToStatus   string
+	Reason     string
+	CreatedAt  time.Time
+}
+
+func (statusHistoryV1) TableName() string { return "status_histories" }
+
+type idempotencyRecordV1 struct {
+	ID             uint   `gorm:"primaryKey;autoIncrement"`
+	Scope          string `gorm:"uniqueIndex:idx_idempotency_scope_key"`
+	IdempotencyKey string `gorm:"uniqueIndex:idx_idempotency_scope_key"`
+	RequestHash    string
+	Status         string
+	StatusCode     int
+	ResponseBody   string
+	ExpiresAt      time.Time `gorm:"index"`
+	CreatedAt      time.Time
+}
+
+func (idempotencyRecordV1) TableName() string { return "idempotency_records" }
+
+/* Table shapes as of version 4, reduced to the keys the constraints need */
+
+type quizSessionV4 struct {
+	ID       uint `gorm:"primaryKey;autoIncrement"`
+	IngestID uint
+	Ingest   *ingestsV1 `gorm:"constraint:OnDelete:RESTRICT"`
+}
+
+func (quizSessionV4) TableName() string { return "quiz_sessions" }
+
+type quizAttemptV4 struct {
+	ID        uint `gorm:"primaryKey;autoIncrement"`
+	SessionID uint
+	Session   *quizSessionV4 `gorm:"constraint:OnDelete:RESTRICT"`
+}
+
+func (quizAttemptV4) TableName() string { return "quiz_attempts" }
+
+/* Table shapes as of version 5 */
+
+type quizSourceV5 struct {
+	ID           uint   `gorm:"primaryKey;autoIncrement"`
+	Name         string `gorm:"uniqueIndex"`
+	StartURL     string
+	SubmitURL    string
+	CorrectField string
+	URLField     string
+	ReasonField  string
+	DelayField   string
+	Active       bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (quizSourceV5) TableName() string { return "quiz_sources" }
+
+type ingestsV5 struct {
+	ID           uint `gorm:"primaryKey;autoIncrement"`
+	QuizSourceID *uint
+	QuizSource   *quizSourceV5 `gorm:"constraint:OnDelete:SET NULL"`
+}
+
+func (ingestsV5) TableName() string { return "ingests" }
+
+/* Table shapes as of version 6 */
+
+type operatorV6 struct {
+	ID           uint   `gorm:"primaryKey;autoIncrement"`
+	Username     string `gorm:"uniqueIndex"`
+	PasswordHash string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (operatorV6) TableName() string { return "operators" }
+
+type operatorSessionV6 struct {
+	ID         uint        `gorm:"primaryKey;autoIncrement"`
+	OperatorID uint        `gorm:"not null;index"`
+	Operator   *operatorV6 `gorm:"constraint:OnDelete:CASCADE"`
+	TokenHash  string      `gorm:"uniqueIndex"`
+	CSRFToken  string
+	ExpiresAt  time.Time `gorm:"index"`
+	CreatedAt  time.Time
+}
+
+func (operatorSessionV6) TableName() string { return "operator_sessions" }
+
+/* Table shapes as of version 7, reduced to the new columns */
+
+type operatorV7 struct {
+	ID   uint `gorm:"primaryKey;autoIncrement"`
+	Role string
+}
+
+func (operatorV7) TableName() string { return "operators" }
+
+type ingestsV7 struct {
+	ID         uint `gorm:"primaryKey;autoIncrement"`
+	AcceptedBy string
+}
+
+func (ingestsV7) TableName() string { return "ingests" }
+
+type quizAttemptV7 struct {
+	ID          uint `gorm:"primaryKey;autoIncrement"`
+	SubmittedBy string
+}
+
+func (quizAttemptV7) TableName() string { return "quiz_attempts" }
+
+/* Table shapes as of version 8 */
+
+type auditEventV8 struct {
+	ID          uint   `gorm:"primaryKey;autoIncrement"`
+	Actor       string `gorm:"index"`
+	Action      string `gorm:"index"`
+	IngestID    *uint  `gorm:"index"`
+	SessionID   *uint  `gorm:"index"`
+	AttemptID   *uint
+	ClientIP    string
+	PayloadHash string
+	Outcome     string
+	Error       string
+	CreatedAt   time.Time `gorm:"index"`
+}
+
+func (auditEventV8) TableName() string { return "audit_events" }
+
+/* Table shapes as of version 9, reduced to the new columns */
+
+type ingestsV9 struct {
+	ID          uint   `gorm:"primaryKey;autoIncrement"`
+	SecretKeyID string `gorm:"not null;default:''"`
+}
+
+func (ingestsV9) TableName() string { return "ingests" }
+
+type quizSessionV9 struct {
+	ID          uint   `gorm:"primaryKey;autoIncrement"`
+	SecretKeyID string `gorm:"not null;default:''"`
+}
+
+func (quizSessionV9) TableName() string { return "quiz_sessions" }
+
+/* Table shapes as of version 10 */
+
+type failedAttemptCounterV10 struct {
+	ID            uint   `gorm:"primaryKey;autoIncrement"`
+	Subject       string `gorm:"uniqueIndex"`
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time `gorm:"index"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (failedAttemptCounterV10) TableName() string { return "failed_attempt_counters" }
+
+/* Table shapes as of version 11, reduced to the indexed columns */
+
+type quizSessionV11 struct {
+	ID     uint   `gorm:"primaryKey;autoIncrement"`
+	Status string `gorm:"index"`
+}
+
+func (quizSessionV11) TableName() string { return "quiz_sessions" }
+
+type quizAttemptV11 struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	SessionID uint      `gorm:"index:idx_quiz_attempts_session_created,priority:1"`
+	Deadline  time.Time `gorm:"index"`
+	CreatedAt time.Time `gorm:"index:idx_quiz_attempts_session_created,priority:2"`
+}
+
+func (quizAttemptV11) TableName() string { return "quiz_attempts" }
+
+/* Table shapes as of version 12, reduced to the new columns */
+
+type quizAttemptV12 struct {
+	ID           uint `gorm:"primaryKey;autoIncrement"`
+	QuestionHTML string
+	FetchedAt    *time.Time
+	FetchError   string
+}
+
+func (quizAttemptV12) TableName() string { return "quiz_attempts" }
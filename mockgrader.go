@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockQuestion is one step of a mock quiz. Answers are compared as trimmed,
+// case-insensitive text, so 42 and "42" both match.
+type MockQuestion struct {
+	Question string `json:"question"`
+	Answer   any    `json:"answer"`
+}
+
+type MockGraderOptions struct {
+	// BaseURL is how clients reach the grader; question URLs are built from it
+	BaseURL   string
+	Questions []MockQuestion
+	// Window is how long each question can be answered after it is issued
+	Window time.Duration
+	// Secret, when set, must accompany every submission
+	Secret string
+	// Encoded serves question pages the way the real grader does, as base64
+	// that a script decodes with atob()
+	Encoded bool
+}
+
+// MockGrader imitates the upstream quiz grader for rehearsals and tests. It
+// serves question pages at /quiz/<step> and grades answers posted to /submit.
+// Posting any answer for the start URL (/start) issues the first question.
+type MockGrader struct {
+	opts MockGraderOptions
+
+	mu   sync.Mutex
+	runs map[string]*mockRun // keyed by email
+}
+
+type mockRun struct {
+	Step     int
+	IssuedAt time.Time
+	Finished bool
+}
+
+type mockSubmission struct {
+	Email  string `json:"email"`
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+	Answer any    `json:"answer"`
+}
+
+type mockResponse struct {
+	Correct bool   `json:"correct"`
+	URL     string `json:"url,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Delay   int    `json:"delay"`
+}
+
+// DefaultMockQuestions builds n arithmetic questions.
+func DefaultMockQuestions(n int) []MockQuestion {
+	questions := make([]MockQuestion, n)
+	for i := range questions {
+		a, b := (i+1)*7, (i+2)*3
+		questions[i] = MockQuestion{
+			Question: fmt.Sprintf("What is %d + %d?", a, b),
+			Answer:   a + b,
+		}
+	}
+	return questions
+}
+
+func NewMockGrader(opts MockGraderOptions) *MockGrader {
+	opts.BaseURL = strings.TrimRight(opts.BaseURL, "/")
+	if len(opts.Questions) == 0 {
+		opts.Questions = DefaultMockQuestions(3)
+	}
+	if opts.Window <= 0 {
+		opts.Window = 3 * time.Minute
+	}
+
+	return &MockGrader{opts: opts, runs: map[string]*mockRun{}}
+}
+
+func (g *MockGrader) StartURL() string { return g.opts.BaseURL + "/start" }
+
+func (g *MockGrader) SubmitURL() string { return g.opts.BaseURL + "/submit" }
+
+func (g *MockGrader) QuestionURL(step int) string {
+	return fmt.Sprintf("%s/quiz/%d", g.opts.BaseURL, step+1)
+}
+
+func (g *MockGrader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/submit":
+		g.handleSubmit(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/quiz/"):
+		g.handleQuestion(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (g *MockGrader) handleQuestion(w http.ResponseWriter, r *http.Request) {
+	step, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/quiz/"))
+	if err != nil || step < 1 || step > len(g.opts.Questions) {
+		http.NotFound(w, r)
+		return
+	}
+
+	question := g.opts.Questions[step-1]
+
+	content := fmt.Sprintf(`<h1>Question %d of %d</h1>
+<div id="question">%s</div>
+<p>POST your answer as JSON to <code id="submit-url">%s</code>:</p>
+<pre>{"email": "you@example.com", "secret": "...", "url": "%s", "answer": ...}</pre>
+`, step, len(g.opts.Questions), html.EscapeString(question.Question),
+		html.EscapeString(g.SubmitURL()), html.EscapeString(g.QuestionURL(step-1)))
+
+	if g.opts.Encoded {
+		content = fmt.Sprintf(`<div id="result"></div>
+<script>
+document.querySelector("#result").innerHTML = atob(`+"`%s`"+`);
+</script>
+`, base64.StdEncoding.EncodeToString([]byte(content)))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<!doctype html>
+<html>
+<head><title>Question %d</title></head>
+<body>
+%s</body>
+</html>
+`, step, content)
+}
+
+func (g *MockGrader) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var submission mockSubmission
+	if err := json.NewDecoder(r.Body).Decode(&submission); err != nil {
+		writeMockJSON(w, http.StatusBadRequest, mockResponse{Reason: "invalid JSON body"})
+		return
+	}
+
+	if submission.Email == "" {
+		writeMockJSON(w, http.StatusBadRequest, mockResponse{Reason: "email is required"})
+		return
+	}
+
+	if g.opts.Secret != "" && submission.Secret != g.opts.Secret {
+		writeMockJSON(w, http.StatusForbidden, mockResponse{Reason: "invalid secret"})
+		return
+	}
+
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// The start URL (re)starts the run and hands out the first question
+	if submission.URL == g.StartURL() {
+		g.runs[submission.Email] = &mockRun{Step: 0, IssuedAt: now}
+		writeMockJSON(w, http.StatusOK, mockResponse{Correct: true, URL: g.QuestionURL(0)})
+		return
+	}
+
+	// A run announced by someone else starts with its first answer
+	run, ok := g.runs[submission.Email]
+	if !ok && submission.URL == g.QuestionURL(0) {
+		run = &mockRun{Step: 0, IssuedAt: now}
+		g.runs[submission.Email] = run
+		ok = true
+	}
+
+	if !ok || run.Finished {
+		writeMockJSON(w, http.StatusOK, mockResponse{Reason: "no quiz in progress for this email"})
+		return
+	}
+
+	if submission.URL != g.QuestionURL(run.Step) {
+		writeMockJSON(w, http.StatusOK, mockResponse{Reason: "url does not match the current question"})
+		return
+	}
+
+	delay := int(now.Sub(run.IssuedAt).Seconds())
+	if now.Sub(run.IssuedAt) > g.opts.Window {
+		writeMockJSON(w, http.StatusOK, mockResponse{Reason: "time limit exceeded", Delay: delay})
+		return
+	}
+
+	expected := g.opts.Questions[run.Step].Answer
+	if !strings.EqualFold(strings.TrimSpace(fmt.Sprint(submission.Answer)), strings.TrimSpace(fmt.Sprint(expected))) {
+		writeMockJSON(w, http.StatusOK, mockResponse{Reason: "wrong answer", Delay: delay})
+		return
+	}
+
+	run.Step++
+	run.IssuedAt = now
+
+	if run.Step >= len(g.opts.Questions) {
+		run.Finished = true
+		writeMockJSON(w, http.StatusOK, mockResponse{Correct: true, Reason: "quiz complete", Delay: delay})
+		return
+	}
+
+	writeMockJSON(w, http.StatusOK, mockResponse{Correct: true, URL: g.QuestionURL(run.Step), Delay: delay})
+}
+
+// KickOff announces a run to our /ingest endpoint the way the real grader
+// does, pointing at the first question.
+func (g *MockGrader) KickOff(ingestURL, email, secret string) error {
+	g.mu.Lock()
+	g.runs[email] = &mockRun{Step: 0, IssuedAt: time.Now()}
+	g.mu.Unlock()
+
+	jsonData, err := json.Marshal(TaskRequest{Email: email, Secret: secret, Url: g.QuestionURL(0)})
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(ingestURL, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to post ingest: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, body mockResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// LoadMockQuestions reads a JSON array of questions from a file.
+func LoadMockQuestions(path string) ([]MockQuestion, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read questions file: %v", err)
+	}
+
+	var questions []MockQuestion
+	if err := json.Unmarshal(data, &questions); err != nil {
+		return nil, fmt.Errorf("failed to parse questions file: %v", err)
+	}
+
+	return questions, nil
+}
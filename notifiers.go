@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type Notification struct {
+	Title   string
+	Message string
+	Ingest  *Ingests
+	Actions []NotificationAction
+}
+
+// NotificationAction is a button that performs an HTTP request when tapped.
+// Only channels with native action support (ntfy, webhook) render them.
+type NotificationAction struct {
+	Label  string `json:"label"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Notifier delivers a notification over one channel. Name is used as the
+// channel key in the notification ledger.
+type Notifier interface {
+	Name() string
+	Send(n Notification) error
+}
+
+var Notifiers []Notifier
+
+var notifyHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// InitNotifiers builds the notifiers listed in NOTIFY_CHANNELS. When unset,
+// ntfy is used if NTFY_TOPIC is configured.
+func InitNotifiers(cfg *Config) error {
+	Notifiers = nil
+
+	for _, channel := range cfg.NotifyChannels {
+		notifier, err := newNotifier(cfg, channel)
+		if err != nil {
+			return err
+		}
+
+		Notifiers = append(Notifiers, notifier)
+	}
+
+	return nil
+}
+
+func newNotifier(cfg *Config, channel string) (Notifier, error) {
+	switch channel {
+	case NOTIFICATION_CHANNEL_NTFY:
+		if cfg.NtfyTopic == "" {
+			return nil, fmt.Errorf("NTFY_TOPIC must be set for the ntfy channel")
+		}
+		return &NtfyNotifier{
+			BaseURL: cfg.NtfyURL,
+			Topic:   cfg.NtfyTopic,
+			Token:   cfg.NtfyToken,
+		}, nil
+
+	case NOTIFICATION_CHANNEL_WEBHOOK:
+		if cfg.WebhookURL == "" {
+			return nil, fmt.Errorf("WEBHOOK_URL must be set for the webhook channel")
+		}
+		return &WebhookNotifier{URL: cfg.WebhookURL}, nil
+
+	case NOTIFICATION_CHANNEL_SLACK:
+		if cfg.SlackWebhookURL == "" {
+			return nil, fmt.Errorf("SLACK_WEBHOOK_URL must be set for the slack channel")
+		}
+		return &ChatWebhookNotifier{Channel: channel, URL: cfg.SlackWebhookURL, TextField: "text"}, nil
+
+	case NOTIFICATION_CHANNEL_DISCORD:
+		if cfg.DiscordWebhookURL == "" {
+			return nil, fmt.Errorf("DISCORD_WEBHOOK_URL must be set for the discord channel")
+		}
+		return &ChatWebhookNotifier{Channel: channel, URL: cfg.DiscordWebhookURL, TextField: "content"}, nil
+
+	case NOTIFICATION_CHANNEL_TELEGRAM:
+		if cfg.TelegramBotToken == "" || cfg.TelegramChatID == "" {
+			return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID must be set for the telegram channel")
+		}
+		return &TelegramNotifier{
+			BaseURL: cfg.TelegramAPIURL,
+			Token:   cfg.TelegramBotToken,
+			ChatID:  cfg.TelegramChatID,
+		}, nil
+
+	case NOTIFICATION_CHANNEL_EMAIL:
+		if cfg.SMTPHost == "" || cfg.SMTPFrom == "" || len(cfg.SMTPTo) == 0 {
+			return nil, fmt.Errorf("SMTP_HOST, SMTP_FROM and SMTP_TO must be set for the email channel")
+		}
+		return &SMTPNotifier{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+			To:       cfg.SMTPTo,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown notification channel %q", channel)
+}
+
+// SendNotification fans a notification out to every configured notifier.
+// Delivery to one channel does not stop the others.
+func SendNotification(n Notification) error {
+	var errs []error
+
+	for _, notifier := range Notifiers {
+		if err := notifier.Send(n); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", notifier.Name(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// NtfyNotifier posts plain text to an ntfy topic. BaseURL may point to a
+// self-hosted server.
+type NtfyNotifier struct {
+	BaseURL string
+	Topic   string
+	Token   string
+}
+
+func (n *NtfyNotifier) Name() string { return NOTIFICATION_CHANNEL_NTFY }
+
+func (n *NtfyNotifier) Send(notification Notification) error {
+	req, err := http.NewRequest(http.MethodPost, n.BaseURL+"/"+n.Topic, strings.NewReader(notification.Message))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain")
+	if notification.Title != "" {
+		req.Header.Set("Title", notification.Title)
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	if len(notification.Actions) > 0 {
+		actions, err := ntfyActionsHeader(notification.Actions)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Actions", actions)
+	}
+
+	return doNotificationRequest(req)
+}
+
+// ntfyActionsHeader renders actions in the JSON form of the X-Actions header.
+// The JSON form is used because request bodies contain commas and quotes.
+func ntfyActionsHeader(actions []NotificationAction) (string, error) {
+	type ntfyAction struct {
+		Action  string            `json:"action"`
+		Label   string            `json:"label"`
+		URL     string            `json:"url"`
+		Method  string            `json:"method,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Body    string            `json:"body,omitempty"`
+		Clear   bool              `json:"clear"`
+	}
+
+	var rendered []ntfyAction
+	for _, action := range actions {
+		item := ntfyAction{
+			Action: "http",
+			Label:  action.Label,
+			URL:    action.URL,
+			Method: action.Method,
+			Body:   action.Body,
+			Clear:  true,
+		}
+		if action.Body != "" {
+			item.Headers = map[string]string{"Content-Type": "application/json"}
+		}
+		rendered = append(rendered, item)
+	}
+
+	jsonData, err := json.Marshal(rendered)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling ntfy actions: %v", err)
+	}
+
+	return string(jsonData), nil
+}
+
+// WebhookNotifier posts a generic JSON document describing the notification.
+type WebhookNotifier struct {
+	URL string
+}
+
+type webhookPayload struct {
+	Title    string     `json:"title"`
+	Message  string     `json:"message"`
+	IngestID uint       `json:"ingestId,omitempty"`
+	Email    string     `json:"email,omitempty"`
+	URL      string     `json:"url,omitempty"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+
+	Actions []NotificationAction `json:"actions,omitempty"`
+}
+
+func (n *WebhookNotifier) Name() string { return NOTIFICATION_CHANNEL_WEBHOOK }
+
+func (n *WebhookNotifier) Send(notification Notification) error {
+	payload := webhookPayload{
+		Title:   notification.Title,
+		Message: notification.Message,
+		Actions: notification.Actions,
+	}
+
+	if ingest := notification.Ingest; ingest != nil {
+		payload.IngestID = ingest.ID
+		payload.Email = ingest.Email
+		payload.URL = ingest.URL
+		payload.Deadline = &ingest.Deadline
+	}
+
+	return postNotificationJSON(n.URL, payload)
+}
+
+// ChatWebhookNotifier covers Slack and Discord incoming webhooks, which only
+// differ in the name of the text field.
+type ChatWebhookNotifier struct {
+	Channel   string
+	URL       string
+	TextField string
+}
+
+func (n *ChatWebhookNotifier) Name() string { return n.Channel }
+
+func (n *ChatWebhookNotifier) Send(notification Notification) error {
+	return postNotificationJSON(n.URL, map[string]string{
+		n.TextField: formatNotificationText(notification),
+	})
+}
+
+// TelegramNotifier sends a message through the Bot API sendMessage method.
+type TelegramNotifier struct {
+	BaseURL string
+	Token   string
+	ChatID  string
+}
+
+func (n *TelegramNotifier) Name() string { return NOTIFICATION_CHANNEL_TELEGRAM }
+
+func (n *TelegramNotifier) Send(notification Notification) error {
+	return postNotificationJSON(fmt.Sprintf("%s/bot%s/sendMessage", n.BaseURL, n.Token), map[string]string{
+		"chat_id": n.ChatID,
+		"text":    formatNotificationText(notification),
+	})
+}
+
+// SMTPNotifier emails the notification. Auth is only used when a username is set.
+type SMTPNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (n *SMTPNotifier) Name() string { return NOTIFICATION_CHANNEL_EMAIL }
+
+func (n *SMTPNotifier) Send(notification Notification) error {
+	subject := notification.Title
+	if subject == "" {
+		subject = "project-2-sdt notification"
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(notification.Message, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	return smtp.SendMail(n.Host+":"+n.Port, auth, n.From, n.To, msg.Bytes())
+}
+
+func formatNotificationText(notification Notification) string {
+	if notification.Title == "" {
+		return notification.Message
+	}
+
+	return notification.Title + "\n" + notification.Message
+}
+
+func postNotificationJSON(url string, payload any) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshaling notification: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return doNotificationRequest(req)
+}
+
+func doNotificationRequest(req *http.Request) error {
+	resp, err := notifyHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
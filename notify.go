@@ -1,41 +1,205 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 	"log"
 	"net/http"
-	"os"
-	"strings"
+	"time"
 )
 
 const NOTIFICATION_TEXT = "We have an ingest task for project-2-sdt, please intervene!"
 
+const NOTIFICATION_TITLE = "Pending ingest"
+
+const (
+	NOTIFICATION_CHANNEL_NTFY     = "ntfy"
+	NOTIFICATION_CHANNEL_WEBHOOK  = "webhook"
+	NOTIFICATION_CHANNEL_EMAIL    = "email"
+	NOTIFICATION_CHANNEL_SLACK    = "slack"
+	NOTIFICATION_CHANNEL_DISCORD  = "discord"
+	NOTIFICATION_CHANNEL_TELEGRAM = "telegram"
+	NOTIFICATION_CHANNEL_SIP      = "sip"
+)
+
+// Delay before retrying a delivery that failed outright
+const NOTIFICATION_RETRY_DELAY = 10 * time.Second
+
+// Re-announcement schedule after each successful delivery. The last entry
+// repeats until the ingest is accepted or expires.
+var notificationBackoff = []time.Duration{30 * time.Second, 60 * time.Second}
+
 func NotifyJob() {
 	var ingests []Ingests
-	if err := DB.Where("status = ?", IngestStatusPending).Find(&ingests).Error; err != nil {
+	if err := DB.Where("status = ? AND deadline > ?", IngestStatusPending, time.Now()).Find(&ingests).Error; err != nil {
 		return
 	}
 
 	for _, ingest := range ingests {
-		notification := NOTIFICATION_TEXT + "\n"
-		notification += "\nEmail: " + ingest.Email
-		notification += "\nURL: " + ingest.URL
+		// Built on first use so an accept token is only issued when something is sent
+		var notification *Notification
+
+		for _, notifier := range Notifiers {
+			entry, due, err := notificationDue(ingest.ID, notifier.Name(), 0)
+			if err != nil {
+				log.Printf("Failed to read notification ledger for ingest ID %d: %v", ingest.ID, err)
+				continue
+			}
+
+			if !due {
+				continue
+			}
 
-		err := SendNotification(notification)
+			if notification == nil {
+				notification = buildIngestNotification(ingest)
+			}
 
-		if err != nil {
-			log.Printf("Failed to send notification for ingest ID %d: %v", ingest.ID, err)
+			err = notifier.Send(*notification)
+
+			if err != nil {
+				log.Printf("Failed to send %s notification for ingest ID %d: %v", notifier.Name(), ingest.ID, err)
+			} else {
+				log.Printf("Sent %s notification for ingest ID %d", notifier.Name(), ingest.ID)
+			}
+
+			if err := recordNotification(entry, err); err != nil {
+				log.Printf("Failed to update notification ledger for ingest ID %d: %v", ingest.ID, err)
+			}
 		}
+
+		EscalateIngest(ingest)
+	}
+
+	if err := stopFinishedNotifications(); err != nil {
+		log.Printf("Failed to stop finished notifications: %v", err)
 	}
 }
 
-func SendNotification(message string) error {
-	_, err := http.Post(fmt.Sprintf("https://ntfy.sh/%s", os.Getenv("NTFY_TOPIC")),
-		"text/plain",
-		strings.NewReader(message),
-	)
+// buildIngestNotification prepares the announcement for a pending ingest.
+// When PUBLIC_BASE_URL and ACCEPT_TOKEN_SECRET are set it carries a one-tap
+// accept action backed by a fresh single-use token.
+func buildIngestNotification(ingest Ingests) *Notification {
+	message := NOTIFICATION_TEXT + "\n"
+	message += "\nEmail: " + ingest.Email
+	message += "\nURL: " + ingest.URL
+
+	notification := &Notification{
+		Title:   NOTIFICATION_TITLE,
+		Message: message,
+		Ingest:  &ingest,
+	}
+
+	baseURL := AppConfig.PublicBaseURL
+	if baseURL == "" || AppConfig.AcceptTokenSecret == "" {
+		return notification
+	}
+
+	token, err := IssueAcceptToken(ingest.ID, ingest.Deadline)
+	if err != nil {
+		log.Printf("Failed to issue accept token for ingest ID %d: %v", ingest.ID, err)
+		return notification
+	}
+
+	body, _ := json.Marshal(map[string]string{"token": token})
+
+	notification.Actions = []NotificationAction{{
+		Label:  "Accept",
+		Method: http.MethodPost,
+		URL:    baseURL + "/ingest/token-accept",
+		Body:   string(body),
+	}}
+
+	return notification
+}
+
+// notificationDue loads (or creates) the ledger entry for an ingest and
+// channel and reports whether another announcement should go out now.
+// maxAttempts of 0 means the channel re-announces until stopped.
+//
+// A due entry is leased by pushing NextSendAt forward, so when several
+// replicas share the database only one of them sends.
+func notificationDue(ingestID uint, channel string, maxAttempts int) (*NotificationLedger, bool, error) {
+	entry := NotificationLedger{
+		IngestID: ingestID,
+		Channel:  channel,
+		Status:   NotificationStatusPending,
+	}
+
+	if err := DB.Where("ingest_id = ? AND channel = ?", ingestID, channel).FirstOrCreate(&entry).Error; err != nil {
+		return nil, false, err
+	}
+
+	if entry.Status == NotificationStatusStopped {
+		return &entry, false, nil
+	}
+
+	if maxAttempts > 0 && entry.Attempts >= maxAttempts {
+		return &entry, false, nil
+	}
+
+	now := time.Now()
+	if now.Before(entry.NextSendAt) {
+		return &entry, false, nil
+	}
+
+	result := DB.Model(&NotificationLedger{}).
+		Where("id = ? AND attempts = ? AND next_send_at <= ?", entry.ID, entry.Attempts, now).
+		Update("next_send_at", now.Add(NOTIFICATION_RETRY_DELAY))
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &entry, false, nil
+	}
+
+	return &entry, true, nil
+}
+
+// recordNotification stores the outcome of a delivery attempt and schedules
+// the next one according to notificationBackoff.
+func recordNotification(entry *NotificationLedger, sendErr error) error {
+	now := time.Now()
+
+	entry.Attempts++
+	entry.LastSentAt = now
+
+	if sendErr != nil {
+		entry.Status = NotificationStatusFailed
+		entry.LastError = sendErr.Error()
+		entry.NextSendAt = now.Add(NOTIFICATION_RETRY_DELAY)
+	} else {
+		entry.Status = NotificationStatusSent
+		entry.LastError = ""
+		entry.NextSendAt = now.Add(nextNotificationDelay(entry.Attempts))
+	}
+
+	return DB.Save(entry).Error
+}
+
+// markNotificationFailed records a failure without counting an attempt, e.g.
+// a voice call that failed after it was counted or a caller that could not
+// be set up at all.
+func markNotificationFailed(entry *NotificationLedger, err error) error {
+	return DB.Model(entry).Updates(map[string]any{
+		"status":     NotificationStatusFailed,
+		"last_error": err.Error(),
+	}).Error
+}
+
+func nextNotificationDelay(attempts int) time.Duration {
+	if attempts > len(notificationBackoff) {
+		return notificationBackoff[len(notificationBackoff)-1]
+	}
+
+	return notificationBackoff[attempts-1]
+}
 
-	log.Println("Notification sent:", message)
+// stopFinishedNotifications closes ledger entries for ingests that were
+// accepted or have passed their deadline.
+func stopFinishedNotifications() error {
+	finished := DB.Model(&Ingests{}).Select("id").
+		Where("status <> ? OR deadline <= ?", IngestStatusPending, time.Now())
 
-	return err
+	return DB.Model(&NotificationLedger{}).
+		Where("status <> ? AND ingest_id IN (?)", NotificationStatusStopped, finished).
+		Update("status", NotificationStatusStopped).Error
 }
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+)
+
+const (
+	// Question pages larger than this are cut off before extraction
+	QUESTION_MAX_BYTES = 2 << 20
+	// How many levels of atob() output are themselves searched for atob()
+	QUESTION_MAX_DECODE_DEPTH = 3
+)
+
+// Tracks background fetches, so tests can wait for them to finish
+var questionFetches sync.WaitGroup
+
+var ErrAttemptNotFound = errors.New("quiz attempt not found")
+
+// QuestionPage is the page an attempt's question was read from. It is kept
+// out of QuizAttempt's JSON, since it can be up to QUESTION_MAX_BYTES.
+type QuestionPage struct {
+	AttemptID  uint       `json:"attemptId"`
+	URL        string     `json:"url"`
+	HTML       string     `json:"html"`
+	FetchedAt  *time.Time `json:"fetchedAt"`
+	FetchError string     `json:"fetchError"`
+}
+
+// atob("..."), atob('...'), atob(`...`) or atob(name)
+var atobCallPattern = regexp.MustCompile("atob\\(\\s*(?:\"([^\"]*)\"|'([^']*)'|`([^`]*)`|([A-Za-z_$][\\w$]*))\\s*\\)")
+
+// "POST ... to <url>" on one line, with an absolute or root-relative URL
+var submitURLPattern = regexp.MustCompile("(?i)\\bpost\\b.*?\\bto\\b.*?[\\s(<\"'`]((?:https?://|/[^/\\s\"'<>()`])[^\\s\"'<>()`]*)")
+
+// Elements whose start or end breaks the line in the extracted text
+var questionBlockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "figcaption": true, "footer": true,
+	"form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "tr": true, "ul": true,
+}
+
+var htmlLineBreaks = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+// Elements whose content is never shown
+var questionHiddenTags = map[string]bool{
+	"noscript": true, "script": true, "style": true, "template": true, "title": true,
+}
+
+// fetchQuestionAsync fills in the attempt's question from its page in the
+// background, once the attempt is committed. A QUESTION_FETCH_TIMEOUT of 0
+// turns fetching off.
+func fetchQuestionAsync(attempt QuizAttempt) {
+	if AppConfig.QuestionFetchTimeout <= 0 {
+		return
+	}
+
+	questionFetches.Add(1)
+	go func() {
+		defer questionFetches.Done()
+		if err := FetchQuestion(attempt.ID); err != nil {
+			log.Printf("Failed to fetch question for attempt ID %d: %v", attempt.ID, err)
+		}
+	}()
+}
+
+// FetchQuestion downloads the attempt's URL and stores the page and its
+// visible text on the attempt. A page that cannot be fetched is recorded as
+// a fetch error and leaves the placeholder question in place.
+func FetchQuestion(attemptID uint) error {
+	var attempt QuizAttempt
+	if err := DB.First(&attempt, attemptID).Error; err != nil {
+		return fmt.Errorf("failed to find quiz attempt: %v", err)
+	}
+
+	var session QuizSession
+	if err := DB.First(&session, attempt.SessionID).Error; err != nil {
+		return fmt.Errorf("failed to find quiz session: %v", err)
+	}
+
+	updates := map[string]any{"fetched_at": time.Now(), "fetch_error": ""}
+	var submitURL string
+
+	page, err := fetchQuestionPage(attempt.URL)
+	if err != nil {
+		updates["fetch_error"] = err.Error()
+	} else {
+		updates["question_html"] = page
+		if text := extractQuestionText(page); text != "" {
+			updates["question"] = text
+			submitURL = discoverSubmitURL(text, attempt.URL, quizSourceForIngest(session.IngestID).SubmitURL)
+		} else {
+			updates["fetch_error"] = "question page has no visible text"
+		}
+	}
+
+	if err := DB.Model(&attempt).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to store question: %v", err)
+	}
+
+	// Only a suggestion: an answer recorded in the meantime keeps the URL
+	// it was actually sent to
+	if submitURL != "" {
+		if err := DB.Model(&QuizAttempt{}).Where("id = ? AND answer = ''", attemptID).
+			Update("submit_url", submitURL).Error; err != nil {
+			return fmt.Errorf("failed to store submit URL: %v", err)
+		}
+	}
+	if err := DB.First(&attempt, attemptID).Error; err != nil {
+		return fmt.Errorf("failed to reload quiz attempt: %v", err)
+	}
+
+	Events.Publish(EVENT_ATTEMPT_FETCHED, attempt)
+	return nil
+}
+
+// GetQuestionPage returns the page fetched for an attempt. HTML is empty
+// until the fetch has succeeded.
+func GetQuestionPage(attemptID uint) (*QuestionPage, error) {
+	var attempt QuizAttempt
+	err := DB.Select("id", "url", "question_html", "fetched_at", "fetch_error").First(&attempt, attemptID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAttemptNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuestionPage{
+		AttemptID:  attempt.ID,
+		URL:        attempt.URL,
+		HTML:       attempt.QuestionHTML,
+		FetchedAt:  attempt.FetchedAt,
+		FetchError: attempt.FetchError,
+	}, nil
+}
+
+func fetchQuestionPage(pageURL string) (string, error) {
+	if !isHTTPURL(pageURL) {
+		return "", fmt.Errorf("%q is not an http(s) URL", pageURL)
+	}
+
+	client := &http.Client{Timeout: AppConfig.QuestionFetchTimeout}
+	resp, err := client.Get(pageURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch question page: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("question page returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, QUESTION_MAX_BYTES))
+	if err != nil {
+		return "", fmt.Errorf("failed to read question page: %v", err)
+	}
+
+	return string(body), nil
+}
+
+// extractQuestionText returns the text a reader of the page would see: the
+// visible text of the HTML, plus whatever its scripts decode with atob(),
+// one block per line.
+func extractQuestionText(page string) string {
+	var b strings.Builder
+	writeVisibleText(&b, page, 0)
+
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func writeVisibleText(b *strings.Builder, page string, depth int) {
+	z := html.NewTokenizer(strings.NewReader(page))
+	hidden := ""
+	inPre := 0
+
+	// The link being written, so its target can follow its text
+	linkHref, linkStart := "", 0
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return
+
+		case html.TextToken:
+			text := string(z.Text())
+			switch {
+			case hidden == "" && inPre > 0:
+				b.WriteString(text)
+			case hidden == "":
+				// Line breaks in the source are just spaces to a browser
+				b.WriteString(htmlLineBreaks.Replace(text))
+			case hidden == "script" && depth < QUESTION_MAX_DECODE_DEPTH:
+				// Decoded content is usually HTML assigned to innerHTML
+				for _, decoded := range decodeAtobPayloads(text) {
+					b.WriteString("\n")
+					writeVisibleText(b, decoded, depth+1)
+					b.WriteString("\n")
+				}
+			}
+
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if hidden == "" && questionHiddenTags[tag] {
+				hidden = tag
+			}
+			if tag == "pre" {
+				inPre++
+			}
+			if tag == "a" {
+				linkHref, linkStart = tagAttribute(z, "href"), b.Len()
+			}
+			if questionBlockTags[tag] {
+				b.WriteString("\n")
+			}
+
+		case html.SelfClosingTagToken:
+			if name, _ := z.TagName(); questionBlockTags[string(name)] {
+				b.WriteString("\n")
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if tag == hidden {
+				hidden = ""
+			}
+			if tag == "pre" && inPre > 0 {
+				inPre--
+			}
+			if tag == "a" && hidden == "" && linkHref != "" && !strings.HasPrefix(linkHref, "#") &&
+				!strings.HasPrefix(linkHref, "javascript:") && strings.TrimSpace(b.String()[linkStart:]) != linkHref {
+				// Questions link the files and endpoints they talk about
+				b.WriteString(" (" + linkHref + ")")
+			}
+			if tag == "a" {
+				linkHref = ""
+			}
+			if questionBlockTags[tag] {
+				b.WriteString("\n")
+			}
+		}
+	}
+}
+
+func tagAttribute(z *html.Tokenizer, name string) string {
+	for {
+		key, value, more := z.TagAttr()
+		if string(key) == name {
+			return string(value)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// discoverSubmitURL looks for where the page says to post the answer, e.g.
+// "POST your answer to https://example.com/submit", in the extracted text of
+// a question page. Relative URLs are resolved against the page URL. Only URLs
+// that pass submitURLAllowed are used.
+func discoverSubmitURL(text, pageURL, sourceSubmitURL string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+
+	for _, match := range submitURLPattern.FindAllStringSubmatch(text, -1) {
+		ref, err := url.Parse(strings.TrimRight(match[1], ".,;:!?"))
+		if err != nil {
+			continue
+		}
+		if found := base.ResolveReference(ref).String(); submitURLAllowed(found, pageURL, sourceSubmitURL) {
+			return found
+		}
+	}
+	return ""
+}
+
+// submitURLAllowed reports whether an answer carrying the session's secret
+// may be posted to submitURL: it must be on the question page's host or on
+// the host of the quiz source's submit URL.
+func submitURLAllowed(submitURL, pageURL, sourceSubmitURL string) bool {
+	return isHTTPURL(submitURL) && (sameHost(submitURL, pageURL) || sameHost(submitURL, sourceSubmitURL))
+}
+
+// decodeAtobPayloads finds the atob() calls in a script and decodes their
+// base64 arguments. An argument that names a variable is looked up as a
+// string literal assigned in the same script.
+func decodeAtobPayloads(script string) []string {
+	var decoded []string
+	for _, match := range atobCallPattern.FindAllStringSubmatch(script, -1) {
+		payload := match[1] + match[2] + match[3]
+		if name := match[4]; name != "" {
+			payload = scriptStringValue(script, name)
+		}
+
+		if text, ok := decodeBase64(payload); ok {
+			decoded = append(decoded, text)
+		}
+	}
+	return decoded
+}
+
+// scriptStringValue returns the string literal assigned to name in script,
+// e.g. `const name = "..."`, or "" if there is none.
+func scriptStringValue(script, name string) string {
+	pattern := regexp.MustCompile(`(?:^|[^\w$])` + regexp.QuoteMeta(name) + "\\s*=\\s*(?:\"([^\"]*)\"|'([^']*)'|`([^`]*)`)")
+	match := pattern.FindStringSubmatch(script)
+	if match == nil {
+		return ""
+	}
+	return match[1] + match[2] + match[3]
+}
+
+func decodeBase64(payload string) (string, bool) {
+	payload = strings.Join(strings.Fields(payload), "")
+	if payload == "" {
+		return "", false
+	}
+
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding} {
+		if data, err := encoding.DecodeString(payload); err == nil && utf8.Valid(data) {
+			return string(data), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useQuestionFetching turns on question fetching, which newTestRouter
+// leaves off.
+func useQuestionFetching(t *testing.T) {
+	t.Helper()
+	AppConfig.QuestionFetchTimeout = 5 * time.Second
+}
+
+func mustFindAttempts(t *testing.T, sessionID uint) []QuizAttempt {
+	t.Helper()
+
+	attempts, err := GetQuizAttempts(sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attempts
+}
+
+func TestExtractQuestionText(t *testing.T) {
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	for name, tc := range map[string]struct {
+		page string
+		want string
+	}{
+		"visible text only": {
+			page: `<html><head><title>Q1</title><style>p { color: red }</style></head>
+<body><h1>Question  1</h1><p>What is
+2 + 2?</p><script>console.log("hidden")</script><noscript><p>enable JS</p></noscript></body></html>`,
+			want: "Question 1\nWhat is 2 + 2?",
+		},
+		"entities and line breaks": {
+			page: `<p>a &lt; b<br>c &amp; d</p><ul><li>one</li><li>two</li></ul><pre>{
+  "answer": 1
+}</pre>`,
+			want: "a < b\nc & d\none\ntwo\n{\n\"answer\": 1\n}",
+		},
+		"atob literal": {
+			page: `<div id="result"></div><script>
+document.querySelector("#result").innerHTML = atob(` + "`" + encode("<p>Sum the <b>values</b></p>") + "`" + `);
+</script>`,
+			want: "Sum the values",
+		},
+		"atob of a variable": {
+			page: `<script>const payload = '` + encode("Download the file") + `';
+el.innerHTML = atob(payload);</script><p>Footer</p>`,
+			want: "Download the file\nFooter",
+		},
+		"nested atob": {
+			page: `<script>el.innerHTML = atob("` + encode(`<p>Outer</p><script>x.innerHTML = atob("`+encode("Inner")+`")</script>`) + `")</script>`,
+			want: "Outer\nInner",
+		},
+		"link targets": {
+			page: `<p>Download <a href="/files/data.csv">the data</a> from <a href="https://example.com/x">https://example.com/x</a> <a href="#top">top</a></p>`,
+			want: "Download the data (/files/data.csv) from https://example.com/x top",
+		},
+		"invalid base64 is skipped": {
+			page: `<p>Visible</p><script>atob("not base64!")</script>`,
+			want: "Visible",
+		},
+	} {
+		if got := extractQuestionText(tc.page); got != tc.want {
+			t.Errorf("%s: got %q, want %q", name, got, tc.want)
+		}
+	}
+}
+
+func TestDiscoverSubmitURL(t *testing.T) {
+	const (
+		page   = "https://quiz.example.com/project2/q1"
+		source = "https://grader.example.com/submit"
+	)
+
+	for text, want := range map[string]string{
+		"POST your answer to https://quiz.example.com/submit.":                                     "https://quiz.example.com/submit",
+		"Post this JSON to /submit with your email":                                                "https://quiz.example.com/submit",
+		"Question 3\nPOST the answer to this endpoint (/api/answer)":                               "https://quiz.example.com/api/answer",
+		"Download https://files.example.com/a.pdf\nthen post it to <https://GRADER.example.com/s>": "https://GRADER.example.com/s",
+		"Visit https://quiz.example.com/submit to read more":                                       "",
+		"POST your answer to the grader":                                                           "",
+		"POST to ftp://files.example.com/upload":                                                   "",
+		// Other hosts would receive the secret
+		"Post the total to the grader after downloading https://files.example.com/data.csv": "",
+		"POST your answer to https://quiz.example.com.evil.test/submit":                     "",
+	} {
+		if got := discoverSubmitURL(text, page, source); got != want {
+			t.Errorf("%q: got %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestQuestionFetchedForEachAttempt(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	useQuestionFetching(t)
+	grader := newTestGrader(t, MockGraderOptions{Questions: DefaultMockQuestions(2), Encoded: true})
+
+	session := createRunningSession(t, r, grader, "a@example.com")
+	questionFetches.Wait()
+
+	first := mustFindAttempts(t, session.ID)[0]
+	if !strings.Contains(first.Question, "What is 7 + 6?") {
+		t.Fatalf("unexpected fetched question %q", first.Question)
+	}
+	if first.FetchedAt == nil || first.FetchError != "" {
+		t.Errorf("unexpected fetch state %v %q", first.FetchedAt, first.FetchError)
+	}
+
+	// The page is only served on its own
+	var listed []map[string]any
+	doRequest(t, r, "GET", fmt.Sprintf("/quiz/sessions/%d/attempts", session.ID), nil).
+		expect(t, 200, "attempts_retrieved").decode(t, &listed)
+	if _, ok := listed[0]["questionHtml"]; ok {
+		t.Errorf("attempt listing includes the question page")
+	}
+	var page QuestionPage
+	doRequest(t, r, "GET", fmt.Sprintf("/quiz/attempts/%d/page", first.ID), nil).
+		expect(t, 200, "question_page_retrieved").decode(t, &page)
+	if page.AttemptID != first.ID || page.URL != grader.QuestionURL(0) || !strings.Contains(page.HTML, "atob(") {
+		t.Errorf("unexpected question page %+v", page)
+	}
+	doRequest(t, r, "GET", "/quiz/attempts/99/page", nil).expect(t, 404, "attempt_not_found")
+
+	answer(t, r, grader, session, 0, 13).expect(t, 200, "answer_submitted")
+	questionFetches.Wait()
+
+	attempts := mustFindAttempts(t, session.ID)
+	if next := attempts[1]; next.URL != grader.QuestionURL(1) || !strings.Contains(next.Question, "What is 14 + 9?") {
+		t.Errorf("unexpected next attempt %q: %q", next.URL, next.Question)
+	}
+	// Recording the answer kept the fetched question
+	if attempts[0].Question != first.Question || attempts[0].Correct == nil || !*attempts[0].Correct {
+		t.Errorf("answered attempt lost its question: %+v", attempts[0])
+	}
+}
+
+func TestQuestionFetchFailure(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	useQuestionFetching(t)
+	grader := newTestGrader(t, MockGraderOptions{})
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", grader.QuestionURL(7))).expect(t, 200, "ingest_created")
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword)).
+		expect(t, 200, "ingest_accepted_and_quiz_started")
+	questionFetches.Wait()
+
+	attempt := mustFindAttempts(t, 1)[0]
+	if attempt.FetchError != "question page returned status 404" || attempt.FetchedAt == nil {
+		t.Errorf("unexpected fetch state %v %q", attempt.FetchedAt, attempt.FetchError)
+	}
+	if attempt.Question != "Visit the URL to see the question" {
+		t.Errorf("failed fetch replaced the question with %q", attempt.Question)
+	}
+}
+
+func TestAnswerDefaultsToDiscoveredSubmitURL(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	useQuestionFetching(t)
+	grader := newTestGrader(t, MockGraderOptions{Questions: DefaultMockQuestions(2), Encoded: true})
+
+	session := createRunningSession(t, r, grader, "a@example.com")
+	questionFetches.Wait()
+
+	if attempt := mustFindAttempts(t, session.ID)[0]; attempt.SubmitURL != grader.SubmitURL() {
+		t.Fatalf("discovered submit URL %q, want %q", attempt.SubmitURL, grader.SubmitURL())
+	}
+
+	var response QuizResponse
+	doRequest(t, r, "POST", fmt.Sprintf("/quiz/sessions/%d/answer", session.ID), map[string]any{
+		"password": testAttemptPass,
+		"answer":   AnswerSubmission{Email: session.Email, Secret: testSecret, URL: grader.QuestionURL(0), Answer: 13},
+	}).expect(t, 200, "answer_submitted").decode(t, &response)
+	if !response.Correct || response.URL != grader.QuestionURL(1) {
+		t.Fatalf("answer without submitUrl got %+v", response)
+	}
+	questionFetches.Wait()
+
+	attempts := mustFindAttempts(t, session.ID)
+	if attempts[0].SubmitURL != grader.SubmitURL() || attempts[1].SubmitURL != grader.SubmitURL() {
+		t.Errorf("unexpected submit URLs %q, %q", attempts[0].SubmitURL, attempts[1].SubmitURL)
+	}
+}
+
+func TestAnswerValueModeToDiscoveredSubmitURL(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	useQuestionFetching(t)
+	// The quiz source points elsewhere, so the URL is only allowed for being
+	// on the question page's host
+	grader := newTestGrader(t, MockGraderOptions{Questions: DefaultMockQuestions(2), Secret: testSecret, Encoded: true})
+
+	session := createRunningSession(t, r, grader, "a@example.com")
+	questionFetches.Wait()
+
+	var response QuizResponse
+	doRequest(t, r, "POST", fmt.Sprintf("/quiz/sessions/%d/answer", session.ID), map[string]any{
+		"password": testAttemptPass,
+		"mode":     AnswerModeValue,
+		"answer":   13,
+	}).expect(t, 200, "answer_submitted").decode(t, &response)
+	if !response.Correct || response.URL != grader.QuestionURL(1) {
+		t.Fatalf("value answer to the discovered submit URL got %+v", response)
+	}
+}
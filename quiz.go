@@ -3,18 +3,45 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// How long an in-flight claim on an attempt is honoured. Must exceed the
+// upstream HTTP timeout so a live submission is never reclaimed.
+const ATTEMPT_CLAIM_TIMEOUT = 30 * time.Second
+
+const (
+	SESSIONS_DEFAULT_LIMIT = 50
+	SESSIONS_MAX_LIMIT     = 200
 )
 
+var (
+	ErrAttemptInFlight   = errors.New("an answer for this attempt is already being submitted")
+	ErrSubmitURLRequired = errors.New("submitUrl is required, none was found on the question page")
+	// Value mode adds the session's secret, so it only posts to the grader
+	ErrSubmitURLNotAllowed = errors.New("value mode only posts to the quiz source's submit host, use raw mode for other hosts")
+)
+
+var quizHTTPClient = &http.Client{Timeout: 20 * time.Second}
+
 type QuizResponse struct {
 	Correct bool   `json:"correct"`
 	URL     string `json:"url,omitempty"`
 	Reason  string `json:"reason,omitempty"`
 }
 
+// AnswerSubmission is the body the grader expects for an answer.
 type AnswerSubmission struct {
 	Email  string      `json:"email"`
 	Secret string      `json:"secret"`
@@ -22,176 +49,356 @@ type AnswerSubmission struct {
 	Answer interface{} `json:"answer"`
 }
 
-// StartQuizSession starts a new quiz session for manual solving
-func StartQuizSession(req TaskRequest) error {
-	// Create a new quiz session
-	session := QuizSession{
-		Email:      req.Email,
-		Secret:     req.Secret,
-		CurrentURL: req.Url,
-		Status:     "waiting_for_answer",
-	}
+// AnswerMode says what the operator sent as the answer.
+type AnswerMode string
 
-	if err := DB.Create(&session).Error; err != nil {
-		return fmt.Errorf("failed to create quiz session: %v", err)
-	}
+const (
+	// Only the answer value; the server wraps it in an AnswerSubmission
+	AnswerModeValue AnswerMode = "value"
+	// The whole body to post, sent exactly as given
+	AnswerModeRaw AnswerMode = "raw"
+)
 
-	// Update the corresponding ingest status
-	if err := DB.Model(&Ingests{}).Where("email = ? AND url = ?", req.Email, req.Url).
-		Update("status", IngestStatusRunning).Error; err != nil {
-		return fmt.Errorf("failed to update ingest status: %v", err)
-	}
+// StartQuizSession starts a manual quiz session for an ingest, moving the
+// ingest to Running. The session's email, secret and starting URL are taken
+// from the ingest.
+func StartQuizSession(ingestID uint) error {
+	var ingest Ingests
+	var attempt QuizAttempt
 
-	// Create initial attempt record
-	attempt := QuizAttempt{
-		SessionID: session.ID,
-		URL:       req.Url,
-		Question:  "Visit the URL to see the question",
-		Answer:    "", // Explicitly set empty string
-		Deadline:  time.Now().Add(3 * time.Minute),
-	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&ingest, ingestID).Error; err != nil {
+			return fmt.Errorf("failed to find ingest for quiz session: %v", err)
+		}
+
+		// Move the ingest first so a rejected transition leaves no orphan session
+		if err := transitionIngest(tx, &ingest, IngestStatusRunning, "quiz_session_started"); err != nil {
+			return fmt.Errorf("failed to update ingest status: %w", err)
+		}
 
-	if err := DB.Create(&attempt).Error; err != nil {
-		return fmt.Errorf("failed to create quiz attempt: %v", err)
+		// Create a new quiz session
+		session := QuizSession{
+			IngestID:        ingest.ID,
+			Email:           ingest.Email,
+			Secret:          ingest.Secret, // shares the ingest's sealed data key
+			SecretKeyID:     ingest.SecretKeyID,
+			CurrentURL:      ingest.URL,
+			Status:          QuizSessionStatusWaiting,
+			StatusChangedAt: time.Now(),
+		}
+
+		if err := tx.Create(&session).Error; err != nil {
+			return fmt.Errorf("failed to create quiz session: %v", err)
+		}
+
+		if err := recordStatusHistory(tx, STATUS_ENTITY_QUIZ_SESSION, session.ID, "", string(session.Status), "created"); err != nil {
+			return fmt.Errorf("failed to record session status: %v", err)
+		}
+
+		// Create initial attempt record
+		attempt = QuizAttempt{
+			SessionID: session.ID,
+			URL:       ingest.URL,
+			Question:  "Visit the URL to see the question",
+			Answer:    "", // Explicitly set empty string
+			Deadline:  time.Now().Add(AppConfig.QuizWindow),
+		}
+
+		if err := tx.Create(&attempt).Error; err != nil {
+			return fmt.Errorf("failed to create quiz attempt: %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
+	Events.Publish(EVENT_INGEST_ACCEPTED, ingest)
+	Events.Publish(EVENT_ATTEMPT_CREATED, attempt)
+	fetchQuestionAsync(attempt)
 	return nil
 }
 
-// StartQuizSessionWithIngest starts a new quiz session for manual solving with ingest link
-func StartQuizSessionWithIngest(req TaskRequest, ingestID uint) error {
-	// Create a new quiz session
-	session := QuizSession{
-		IngestID:   ingestID,
-		Email:      req.Email,
-		Secret:     req.Secret,
-		CurrentURL: req.Url,
-		Status:     "waiting_for_answer",
+// SubmitManualAnswer submits a manually provided answer to a custom submit
+// URL, or to the one found on the question page when submitURL is empty.
+// In AnswerModeValue the answer is sent with the session's email and secret
+// and the attempt's URL. The audit actor is recorded on the attempt as its
+// submitter.
+func SubmitManualAnswer(sessionID uint, answerData json.RawMessage, mode AnswerMode, submitURL string, audit AuditContext) (_ *QuizResponse, err error) {
+	// Resolved and checked before the claim, so a request that cannot be
+	// sent leaves the session and its history alone. A missing attempt is
+	// left to the claim.
+	pending, pendingErr := pendingAttempt(sessionID)
+	if submitURL == "" {
+		if pendingErr == nil && pending.SubmitURL == "" {
+			return nil, ErrSubmitURLRequired
+		}
+		submitURL = pending.SubmitURL
 	}
+	if mode != AnswerModeRaw && submitURL != "" {
+		var session QuizSession
+		if err := DB.Select("id", "ingest_id").First(&session, sessionID).Error; err != nil {
+			return nil, fmt.Errorf("failed to find quiz session: %v", err)
+		}
+		if !submitURLAllowed(submitURL, pending.URL, quizSourceForIngest(session.IngestID).SubmitURL) {
+			return nil, ErrSubmitURLNotAllowed
+		}
+	}
+
+	targets := AuditTargets{SessionID: &sessionID}
+	defer func() { recordAuditEvent(audit, AUDIT_ACTION_SUBMIT_ANSWER, targets, err) }()
 
-	if err := DB.Create(&session).Error; err != nil {
-		return fmt.Errorf("failed to create quiz session: %v", err)
+	session, attempt, err := claimPendingAttempt(sessionID)
+	if err != nil {
+		return nil, err
 	}
+	targets.IngestID = &session.IngestID
+	targets.AttemptID = &attempt.ID
 
-	// Update the corresponding ingest status
-	if err := DB.Model(&Ingests{}).Where("id = ?", ingestID).
-		Update("status", IngestStatusRunning).Error; err != nil {
-		return fmt.Errorf("failed to update ingest status: %v", err)
+	if submitURL == "" {
+		// The attempt was created after the check above
+		releaseAttempt(session, attempt, "submit_url_missing")
+		return nil, ErrSubmitURLRequired
+	}
+	// Likewise, the claimed attempt may be on another page than the one
+	// checked above
+	if mode != AnswerModeRaw && !submitURLAllowed(submitURL, attempt.URL, quizSourceForIngest(session.IngestID).SubmitURL) {
+		releaseAttempt(session, attempt, "submit_url_not_allowed")
+		return nil, ErrSubmitURLNotAllowed
 	}
 
-	// Create initial attempt record
-	attempt := QuizAttempt{
-		SessionID: session.ID,
-		URL:       req.Url,
-		Question:  "Visit the URL to see the question",
-		Answer:    "", // Explicitly set empty string
-		Deadline:  time.Now().Add(3 * time.Minute),
+	var body any = answerData
+	if mode != AnswerModeRaw {
+		secret, err := session.DecryptSecret()
+		if err != nil {
+			releaseAttempt(session, attempt, "answer_failed")
+			return nil, fmt.Errorf("failed to decrypt session secret: %v", err)
+		}
+		body = AnswerSubmission{Email: session.Email, Secret: secret, URL: attempt.URL, Answer: answerData}
 	}
 
-	if err := DB.Create(&attempt).Error; err != nil {
-		return fmt.Errorf("failed to create quiz attempt: %v", err)
+	response, err := SubmitRawAnswer(quizSourceForIngest(session.IngestID), submitURL, body)
+	if err != nil {
+		releaseAttempt(session, attempt, "answer_failed")
+		return nil, fmt.Errorf("failed to submit answer: %v", err)
 	}
 
-	return nil
-}
+	// A value is stored exactly as sent; a raw body keeps everything but
+	// the secret
+	answerJSON, _ := json.Marshal(answerData)
+	storedAnswer := string(answerJSON)
+	if mode == AnswerModeRaw {
+		if redacted := redactRawRequest(answerJSON); redacted != "" {
+			storedAnswer = redacted
+		}
+	}
 
-// SubmitManualAnswer submits a manually provided answer to a custom submit URL
-func SubmitManualAnswer(sessionID uint, answerData interface{}, submitURL string) (*QuizResponse, error) {
-	var session QuizSession
-	if err := DB.First(&session, sessionID).Error; err != nil {
-		return nil, fmt.Errorf("failed to find quiz session: %v", err)
+	attempt.SubmittedBy = audit.Actor
+	if err := recordAnswer(session, attempt, storedAnswer, submitURL, response); err != nil {
+		releaseAttempt(session, attempt, "answer_record_failed")
+		return nil, err
 	}
 
-	// Find the latest pending attempt for this session that hasn't expired
+	return response, nil
+}
+
+// pendingAttempt returns the page and submit URLs of the session's latest
+// pending attempt, without claiming it.
+func pendingAttempt(sessionID uint) (QuizAttempt, error) {
 	var attempt QuizAttempt
-	if err := DB.Where("session_id = ? AND answer = '' AND (deadline > ? OR deadline IS NULL OR deadline = ?)", sessionID, time.Now(), time.Time{}).
+	err := DB.Select("url", "submit_url").
+		Where("session_id = ? AND answer = '' AND (deadline > ? OR deadline IS NULL OR deadline = ?)", sessionID, time.Now(), time.Time{}).
 		Order("created_at DESC").
-		First(&attempt).Error; err != nil {
-		return nil, fmt.Errorf("no pending attempt found or attempt expired: %v", err)
-	}
+		First(&attempt).Error
+	return attempt, err
+}
 
-	// Submit the answer directly as provided (not wrapped in submission structure)
-	response, err := SubmitRawAnswer(submitURL, answerData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to submit answer: %v", err)
-	}
+// claimPendingAttempt moves the session to running and marks its latest
+// pending attempt as in flight in one transaction. A second submission for
+// the same attempt gets ErrAttemptInFlight until the claim is released or
+// goes stale.
+func claimPendingAttempt(sessionID uint) (*QuizSession, *QuizAttempt, error) {
+	var session QuizSession
+	var attempt QuizAttempt
 
-	// Update the attempt with the response
-	answerJSON, _ := json.Marshal(answerData)
-	attempt.Answer = string(answerJSON)
-	attempt.SubmitURL = submitURL
-	attempt.Correct = &response.Correct
-	attempt.NextURL = response.URL
-	attempt.Reason = response.Reason
-	responseJSON, _ := json.Marshal(response)
-	attempt.ResponseRaw = string(responseJSON)
-
-	if err := DB.Save(&attempt).Error; err != nil {
-		return nil, fmt.Errorf("failed to update quiz attempt: %v", err)
-	}
-
-	// If we have a next URL, create a new attempt and update session
-	if response.URL != "" {
-		session.CurrentURL = response.URL
-		if err := DB.Save(&session).Error; err != nil {
-			return nil, fmt.Errorf("failed to update session: %v", err)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, sessionID).Error; err != nil {
+			return fmt.Errorf("failed to find quiz session: %v", err)
 		}
 
-		// Create next attempt
-		nextAttempt := QuizAttempt{
-			SessionID: sessionID,
-			URL:       response.URL,
-			Question:  "Visit the URL to see the next question",
-			Deadline:  time.Now().Add(3 * time.Minute),
+		// A session that is already running either has an answer in flight or
+		// a stale claim; the attempt claim below decides which.
+		if session.Status != QuizSessionStatusRunning {
+			if err := transitionSession(tx, &session, QuizSessionStatusRunning, "answer_submitted"); err != nil {
+				return err
+			}
 		}
 
-		if err := DB.Create(&nextAttempt).Error; err != nil {
-			return nil, fmt.Errorf("failed to create next attempt: %v", err)
+		// Find the latest pending attempt for this session that hasn't expired
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("session_id = ? AND answer = '' AND (deadline > ? OR deadline IS NULL OR deadline = ?)", sessionID, time.Now(), time.Time{}).
+			Order("created_at DESC").
+			First(&attempt).Error; err != nil {
+			return fmt.Errorf("no pending attempt found or attempt expired: %v", err)
 		}
 
-		// Extend the ingest deadline to match the new attempt
-		if err := DB.Model(&Ingests{}).Where("email = ?", session.Email).
-			Update("deadline", nextAttempt.Deadline).Error; err != nil {
-			return nil, fmt.Errorf("failed to update ingest deadline: %v", err)
+		now := time.Now()
+		result := tx.Model(&QuizAttempt{}).
+			Where("id = ? AND answer = '' AND (claimed_at IS NULL OR claimed_at < ?)", attempt.ID, now.Add(-ATTEMPT_CLAIM_TIMEOUT)).
+			Update("claimed_at", now)
+		if result.Error != nil {
+			return fmt.Errorf("failed to claim quiz attempt: %v", result.Error)
 		}
-	} else if response.Correct {
-		// Quiz completed successfully (correct answer and no next URL)
-		session.Status = "completed"
-		if err := DB.Save(&session).Error; err != nil {
-			return nil, fmt.Errorf("failed to update session status: %v", err)
+		if result.RowsAffected == 0 {
+			return ErrAttemptInFlight
 		}
 
-		// Update ingest status
-		DB.Model(&Ingests{}).Where("email = ?", session.Email).
-			Update("status", IngestStatusCompleted)
-	} else {
-		// Answer is incorrect and no next URL provided - create a new attempt for retry
-		// Retry attempts inherit the original attempt's deadline (no extension)
-		retryAttempt := QuizAttempt{
-			SessionID: sessionID,
-			URL:       attempt.URL, // Keep the same URL for retry
-			Question:  "Answer was incorrect. You can retry within the remaining time window.",
-			Deadline:  attempt.Deadline, // Keep the same deadline as the original attempt
+		attempt.ClaimedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &session, &attempt, nil
+}
+
+// releaseAttempt drops the in-flight claim and returns the session to
+// waiting_for_answer after a failed submission.
+func releaseAttempt(session *QuizSession, attempt *QuizAttempt, reason string) {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&QuizAttempt{}).Where("id = ?", attempt.ID).Update("claimed_at", nil).Error; err != nil {
+			return err
 		}
 
-		if err := DB.Create(&retryAttempt).Error; err != nil {
-			return nil, fmt.Errorf("failed to create retry attempt: %v", err)
+		// A rolled back recordAnswer leaves its transition on the in-memory
+		// session, so start from the stored status
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(session, session.ID).Error; err != nil {
+			return err
 		}
-		// Note: No deadline extension for retry attempts - users must retry within the original 3-minute window
+
+		return transitionSession(tx, session, QuizSessionStatusWaiting, reason)
+	})
+	if err != nil {
+		log.Printf("Failed to release quiz attempt ID %d: %v", attempt.ID, err)
 	}
+}
 
-	return response, nil
+// recordAnswer stores the upstream response and advances the session in a
+// single transaction, so a failure leaves no half-applied state.
+func recordAnswer(session *QuizSession, attempt *QuizAttempt, answer string, submitURL string, response *QuizResponse) error {
+	sessionID := session.ID
+	var nextAttempt *QuizAttempt
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// Update the attempt with the response
+		attempt.Answer = answer
+		attempt.SubmitURL = submitURL
+		attempt.Correct = &response.Correct
+		attempt.NextURL = response.URL
+		attempt.Reason = response.Reason
+		responseJSON, _ := json.Marshal(response)
+		attempt.ResponseRaw = string(responseJSON)
+
+		// Only the answer columns, so a question fetched in the meantime is kept
+		if err := tx.Model(attempt).
+			Select("answer", "submit_url", "submitted_by", "correct", "next_url", "reason", "response_raw").
+			Updates(attempt).Error; err != nil {
+			return fmt.Errorf("failed to update quiz attempt: %v", err)
+		}
+
+		// If we have a next URL, create a new attempt and update session
+		if response.URL != "" {
+			session.CurrentURL = response.URL
+			if err := tx.Model(session).Update("current_url", response.URL).Error; err != nil {
+				return fmt.Errorf("failed to update session: %v", err)
+			}
+
+			// Create next attempt
+			nextAttempt = &QuizAttempt{
+				SessionID: sessionID,
+				URL:       response.URL,
+				Question:  "Visit the URL to see the next question",
+				Deadline:  time.Now().Add(AppConfig.QuizWindow),
+			}
+
+			if err := tx.Create(nextAttempt).Error; err != nil {
+				return fmt.Errorf("failed to create next attempt: %v", err)
+			}
+
+			// Extend the ingest deadline to match the new attempt
+			if err := tx.Model(&Ingests{}).Where("id = ?", session.IngestID).
+				Update("deadline", nextAttempt.Deadline).Error; err != nil {
+				return fmt.Errorf("failed to update ingest deadline: %v", err)
+			}
+
+			if err := transitionSession(tx, session, QuizSessionStatusWaiting, "next_question"); err != nil {
+				return fmt.Errorf("failed to update session status: %w", err)
+			}
+		} else if response.Correct {
+			// Quiz completed successfully (correct answer and no next URL)
+			if err := transitionSession(tx, session, QuizSessionStatusCompleted, "quiz_completed"); err != nil {
+				return fmt.Errorf("failed to update session status: %w", err)
+			}
+
+			// Update ingest status, unless it was already finalized elsewhere
+			var ingest Ingests
+			if err := tx.First(&ingest, session.IngestID).Error; err != nil {
+				return fmt.Errorf("failed to find ingest to complete: %v", err)
+			}
+			if ingest.Status == IngestStatusRunning {
+				if err := transitionIngest(tx, &ingest, IngestStatusCompleted, "quiz_completed"); err != nil {
+					return fmt.Errorf("failed to update ingest status: %w", err)
+				}
+			}
+		} else {
+			// Answer is incorrect and no next URL provided - create a new attempt for retry
+			// Retry attempts inherit the original attempt's deadline (no extension)
+			nextAttempt = &QuizAttempt{
+				SessionID: sessionID,
+				URL:       attempt.URL, // Keep the same URL for retry
+				Question:  "Answer was incorrect. You can retry within the remaining time window.",
+				Deadline:  attempt.Deadline, // Keep the same deadline as the original attempt
+			}
+
+			if err := tx.Create(nextAttempt).Error; err != nil {
+				return fmt.Errorf("failed to create retry attempt: %v", err)
+			}
+			// Note: No deadline extension for retry attempts - users must retry within the original 3-minute window
+
+			if err := transitionSession(tx, session, QuizSessionStatusWaiting, "answer_incorrect"); err != nil {
+				return fmt.Errorf("failed to update session status: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	Events.Publish(EVENT_ATTEMPT_ANSWERED, *attempt)
+	if nextAttempt != nil {
+		Events.Publish(EVENT_ATTEMPT_CREATED, *nextAttempt)
+		fetchQuestionAsync(*nextAttempt)
+	}
+	if session.Status == QuizSessionStatusCompleted {
+		Events.Publish(EVENT_SESSION_COMPLETED, *session)
+	}
+	return nil
 }
 
-// SubmitRawAnswer submits exactly the answer data provided without wrapping
-func SubmitRawAnswer(submitURL string, answer any) (*QuizResponse, error) {
+// SubmitRawAnswer submits exactly the answer data provided without wrapping.
+// The reply is parsed with the response schema of the quiz source.
+func SubmitRawAnswer(source *QuizSource, submitURL string, answer any) (*QuizResponse, error) {
 	// Submit EXACTLY the answer JSON provided by the user
 	jsonData, err := json.Marshal(answer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal answer: %v", err)
 	}
 
-	resp, err := http.Post(submitURL, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := quizHTTPClient.Post(submitURL, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to submit answer: %v", err)
 	}
@@ -202,12 +409,16 @@ func SubmitRawAnswer(submitURL string, answer any) (*QuizResponse, error) {
 		return nil, fmt.Errorf("failed to read response: %v", err)
 	}
 
-	var quizResp QuizResponse
-	if err := json.Unmarshal(body, &quizResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	parsed, err := source.ParseResponse(body)
+	if err != nil {
+		return nil, err
 	}
 
-	return &quizResp, nil
+	return &QuizResponse{
+		Correct: parsed.Correct,
+		URL:     parsed.URL,
+		Reason:  parsed.Reason,
+	}, nil
 }
 
 // GetCurrentAttempt gets the current pending attempt for a session that hasn't expired
@@ -233,11 +444,146 @@ func GetPendingAttempts() ([]QuizAttempt, error) {
 	return attempts, err
 }
 
-// GetQuizSessions returns all quiz sessions
-func GetQuizSessions() ([]QuizSession, error) {
+// QuizSessionFilter selects quiz sessions. Zero values match everything;
+// Cursor is the ID of the last session of the previous page.
+type QuizSessionFilter struct {
+	Statuses        []QuizSessionStatus
+	Email           string
+	From            time.Time // created at or after
+	To              time.Time // created before
+	Cursor          uint
+	Ascending       bool // oldest first; newest first by default
+	Limit           int
+	IncludeAttempts bool
+}
+
+type QuizSessionPage struct {
+	Sessions   []QuizSession `json:"sessions"`
+	NextCursor *uint         `json:"nextCursor"`
+}
+
+// parseQuizSessionFilter reads a QuizSessionFilter from the query string.
+// Statuses are comma-separated and times are RFC 3339.
+func parseQuizSessionFilter(c *gin.Context) (QuizSessionFilter, error) {
+	filter := QuizSessionFilter{Email: c.Query("email")}
+
+	if value := c.Query("status"); value != "" {
+		for _, status := range strings.Split(value, ",") {
+			switch s := QuizSessionStatus(strings.TrimSpace(status)); s {
+			case QuizSessionStatusWaiting, QuizSessionStatusRunning, QuizSessionStatusCompleted, QuizSessionStatusFailed:
+				filter.Statuses = append(filter.Statuses, s)
+			default:
+				return filter, fmt.Errorf("invalid status %q", status)
+			}
+		}
+	}
+
+	for key, dst := range map[string]*time.Time{
+		"from": &filter.From,
+		"to":   &filter.To,
+	} {
+		if value := c.Query(key); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			*dst = t
+		}
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid cursor: %v", err)
+		}
+		filter.Cursor = uint(n)
+	}
+
+	switch order := c.DefaultQuery("order", "desc"); order {
+	case "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		return filter, fmt.Errorf("invalid order %q, want asc or desc", order)
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit %q", value)
+		}
+		filter.Limit = limit
+	}
+
+	for _, include := range strings.Split(c.Query("include"), ",") {
+		switch include {
+		case "":
+		case "attempts":
+			filter.IncludeAttempts = true
+		default:
+			return filter, fmt.Errorf("cannot include %q", include)
+		}
+	}
+
+	return filter, nil
+}
+
+// ListQuizSessions returns one page of matching sessions. Pages are ordered
+// by ID, which follows creation order, so a cursor stays stable while new
+// sessions are created.
+func ListQuizSessions(filter QuizSessionFilter) (*QuizSessionPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = SESSIONS_DEFAULT_LIMIT
+	}
+	if filter.Limit > SESSIONS_MAX_LIMIT {
+		filter.Limit = SESSIONS_MAX_LIMIT
+	}
+
+	query := DB.Model(&QuizSession{})
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if filter.Email != "" {
+		query = query.Where("email = ?", filter.Email)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+
+	order := "id DESC"
+	if filter.Ascending {
+		order = "id ASC"
+		if filter.Cursor != 0 {
+			query = query.Where("id > ?", filter.Cursor)
+		}
+	} else if filter.Cursor != 0 {
+		query = query.Where("id < ?", filter.Cursor)
+	}
+
+	if filter.IncludeAttempts {
+		// One query for the attempts of the whole page
+		query = query.Preload("Attempts", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("created_at ASC, id ASC")
+		})
+	}
+
+	// Fetch one extra row to know whether there is another page
 	var sessions []QuizSession
-	err := DB.Order("created_at DESC").Find(&sessions).Error
-	return sessions, err
+	if err := query.Order(order).Limit(filter.Limit + 1).Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	page := QuizSessionPage{Sessions: sessions}
+	if len(sessions) > filter.Limit {
+		page.Sessions = sessions[:filter.Limit]
+		cursor := page.Sessions[filter.Limit-1].ID
+		page.NextCursor = &cursor
+	}
+
+	return &page, nil
 }
 
 // GetQuizAttempts returns all attempts for a session
@@ -249,9 +595,29 @@ func GetQuizAttempts(sessionID uint) ([]QuizAttempt, error) {
 	return attempts, err
 }
 
-// ExtendSessionDeadline extends the deadline for all ingests matching the email
-func ExtendSessionDeadline(email string, extension time.Duration) error {
-	return DB.Model(&Ingests{}).
-		Where("email = ? AND status IN (?, ?)", email, IngestStatusRunning, IngestStatusNotified).
-		Update("deadline", DB.Raw("datetime(deadline, '+' || ? || ' seconds')", int(extension.Seconds()))).Error
+// ExtendSessionDeadline extends the deadline of the ingest behind a session
+func ExtendSessionDeadline(sessionID uint, extension time.Duration, audit AuditContext) (err error) {
+	defer func() {
+		recordAuditEvent(audit, AUDIT_ACTION_EXTEND_DEADLINE, AuditTargets{SessionID: &sessionID}, err)
+	}()
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var session QuizSession
+		if err := tx.First(&session, sessionID).Error; err != nil {
+			return fmt.Errorf("failed to find quiz session: %v", err)
+		}
+
+		var ingest Ingests
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND status IN (?, ?)", session.IngestID, IngestStatusRunning, IngestStatusNotified).
+			First(&ingest).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&ingest).Update("deadline", ingest.Deadline.Add(extension)).Error
+	})
 }
@@ -0,0 +1,809 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NewRouter builds the HTTP API. It expects DB and AppConfig to be set up;
+// background jobs are started separately by main.
+func NewRouter(cfg *Config) *gin.Engine {
+	r := gin.Default()
+
+	// Without trusted proxies, X-Forwarded-For is ignored and the client IP
+	// (used for lockouts and the audit log) is the connection's address.
+	// The list is checked by Config.Validate.
+	r.SetTrustedProxies(cfg.TrustedProxies)
+
+	/* Frontend */
+	r.Static("/assets", "./public/assets")
+	r.GET("/quiz-interface", func(c *gin.Context) {
+		c.File("./public/index.html")
+	})
+	r.NoRoute(func(c *gin.Context) {
+		c.File("./public/index.html")
+	})
+
+	/* Operator login */
+	r.POST("/auth/login", LimitFailedAttempts(LockoutOptions{
+		Scope:  "login",
+		Target: jsonBodyField("username"),
+	}), func(c *gin.Context) {
+		var body struct {
+			Username string `json:"username" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_request_body",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		operator, session, token, err := Login(body.Username, body.Password)
+		if errors.Is(err, ErrInvalidCredentials) {
+			c.JSON(401, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_credentials",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "login_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		setSessionCookie(c, token, int(cfg.OperatorSessionTTL.Seconds()))
+
+		c.JSON(200, APIResponse[OperatorInfo]{
+			Status:  "success",
+			Message: "logged_in",
+			Error:   "",
+			Data:    OperatorInfo{Username: operator.Username, Role: operator.Role, CSRFToken: session.CSRFToken},
+		})
+	})
+
+	authGroup := r.Group("/auth")
+	authGroup.Use(EnsureAuthenticated())
+
+	// Lets the dashboard pick up an existing session and its CSRF token
+	authGroup.GET("/me", func(c *gin.Context) {
+		session := c.MustGet("operatorSession").(*OperatorSession)
+
+		c.JSON(200, APIResponse[OperatorInfo]{
+			Status:  "success",
+			Message: "operator_retrieved",
+			Error:   "",
+			Data:    OperatorInfo{Username: currentOperator(c).Username, Role: currentOperator(c).Role, CSRFToken: session.CSRFToken},
+		})
+	})
+
+	authGroup.POST("/logout", func(c *gin.Context) {
+		session := c.MustGet("operatorSession").(*OperatorSession)
+
+		if err := Logout(session.ID); err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "logout_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		setSessionCookie(c, "", -1)
+
+		c.JSON(200, APIResponse[any]{
+			Status:  "success",
+			Message: "logged_out",
+			Error:   "",
+			Data:    nil,
+		})
+	})
+
+	// POST /ingest and /ingest/token-accept authenticate with the shared
+	// secret and signed tokens; everything else needs an operator session
+	ingestGroup := r.Group("/ingest")
+	ingestGroup.POST("", LimitFailedAttempts(LockoutOptions{Scope: "ingest"}), RequireIngestSecret(), Idempotent(IdempotencyOptions{
+		Scope:      func(c *gin.Context) string { return "ingest" },
+		DeriveKey:  DeriveIngestIdempotencyKey,
+		DerivedTTL: cfg.QuizWindow,
+	}), func(c *gin.Context) {
+		var req TaskRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_request_body",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		// Tasks are attributed to whichever quiz source is active
+		source, err := GetActiveQuizSource()
+		if err != nil && !errors.Is(err, ErrQuizSourceNotFound) {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "quiz_source_lookup_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		_, err = Ingest(req, source)
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "ingest_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[any]{
+			Status:  "success",
+			Message: "ingest_created",
+			Error:   "",
+			Data:    nil,
+		})
+	})
+
+	ingestGroup.GET("", EnsureAuthenticated(), func(c *gin.Context) {
+		ingests, err := ListIngests()
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "list_ingests_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[[]Ingests]{
+			Status:  "success",
+			Message: "ingests_listed",
+			Error:   "",
+			Data:    ingests,
+		})
+	})
+
+	// The password travels in the body so it stays out of access logs
+	ingestGroup.POST("/notification-accept", EnsureAuthenticated(), RequirePermission(PermissionAccept), LimitFailedAttempts(LockoutOptions{
+		Scope:  "accept",
+		Target: jsonBodyField("id"),
+	}), func(c *gin.Context) {
+		audit := newAuditContext(c, currentOperator(c).Username)
+
+		var body struct {
+			ID       uint   `json:"id" binding:"required"`
+			Password string `json:"password"`
+		}
+
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_request_body",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+		id := body.ID
+
+		err := AcceptIngest(id, body.Password, audit)
+		if errors.Is(err, ErrInvalidPassword) {
+			c.JSON(401, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_password",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		var transitionErr *TransitionError
+		if errors.As(err, &transitionErr) {
+			c.JSON(409, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_state_transition",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "accept_ingest_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		// Start the quiz session now that it's accepted
+		err = StartQuizSession(id)
+		if errors.As(err, &transitionErr) {
+			c.JSON(409, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_state_transition",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "quiz_session_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[any]{
+			Status:  "success",
+			Message: "ingest_accepted_and_quiz_started",
+			Error:   "",
+			Data:    nil,
+		})
+	})
+
+	ingestGroup.GET("/:id/history", EnsureAuthenticated(), func(c *gin.Context) {
+		var id uint
+		_, err := fmt.Sscanf(c.Param("id"), "%d", &id)
+		if err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_id_parameter",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		history, err := GetStatusHistory(STATUS_ENTITY_INGEST, id)
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "failed_to_get_status_history",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[[]StatusHistory]{
+			Status:  "success",
+			Message: "status_history_retrieved",
+			Error:   "",
+			Data:    history,
+		})
+	})
+
+	// One-tap accept from notification actions. The signed, single-use
+	// token replaces the shared accept password.
+	ingestGroup.POST("/token-accept", LimitFailedAttempts(LockoutOptions{Scope: "token-accept"}), func(c *gin.Context) {
+		audit := newAuditContext(c, "accept-token")
+
+		var body struct {
+			Token string `json:"token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_request_body",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		// A used token is genuine, so it is a conflict rather than a failed
+		// guess for the lockout
+		id, err := ConsumeAcceptToken(body.Token)
+		if errors.Is(err, ErrAcceptTokenUsed) {
+			c.JSON(409, APIResponse[any]{
+				Status:  "error",
+				Message: "accept_token_used",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if err != nil {
+			c.JSON(403, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_accept_token",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		err = ActivateIngest(id, audit)
+		// Let the same notification be tapped again unless the ingest was
+		// already accepted
+		if err != nil && !errors.Is(err, ErrIngestNotPending) {
+			if releaseErr := ReleaseAcceptToken(body.Token); releaseErr != nil {
+				log.Printf("Failed to release accept token for ingest ID %d: %v", id, releaseErr)
+			}
+		}
+
+		if errors.Is(err, ErrIngestNotPending) {
+			c.JSON(409, APIResponse[any]{
+				Status:  "error",
+				Message: "ingest_not_pending",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		var transitionErr *TransitionError
+		if errors.As(err, &transitionErr) {
+			c.JSON(409, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_state_transition",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "accept_ingest_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[any]{
+			Status:  "success",
+			Message: "ingest_accepted_and_quiz_started",
+			Error:   "",
+			Data:    nil,
+		})
+	})
+
+	// Quiz management endpoints
+	quizGroup := r.Group("/quiz")
+	quizGroup.Use(EnsureAuthenticated())
+
+	// Get all quiz sessions
+	quizGroup.GET("/sessions", func(c *gin.Context) {
+		filter, err := parseQuizSessionFilter(c)
+		if err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_session_filter",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		page, err := ListQuizSessions(filter)
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "failed_to_get_sessions",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[*QuizSessionPage]{
+			Status:  "success",
+			Message: "sessions_retrieved",
+			Error:   "",
+			Data:    page,
+		})
+	})
+
+	// Get attempts for a specific session
+	quizGroup.GET("/sessions/:id/attempts", func(c *gin.Context) {
+		var sessionID uint
+		_, err := fmt.Sscanf(c.Param("id"), "%d", &sessionID)
+		if err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_session_id",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		attempts, err := GetQuizAttempts(sessionID)
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "failed_to_get_attempts",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[[]QuizAttempt]{
+			Status:  "success",
+			Message: "attempts_retrieved",
+			Error:   "",
+			Data:    attempts,
+		})
+	})
+
+	// Get the status history of a session
+	quizGroup.GET("/sessions/:id/history", func(c *gin.Context) {
+		var sessionID uint
+		_, err := fmt.Sscanf(c.Param("id"), "%d", &sessionID)
+		if err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_session_id",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		history, err := GetStatusHistory(STATUS_ENTITY_QUIZ_SESSION, sessionID)
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "failed_to_get_status_history",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[[]StatusHistory]{
+			Status:  "success",
+			Message: "status_history_retrieved",
+			Error:   "",
+			Data:    history,
+		})
+	})
+
+	// Get the question page fetched for an attempt
+	quizGroup.GET("/attempts/:id/page", func(c *gin.Context) {
+		var attemptID uint
+		_, err := fmt.Sscanf(c.Param("id"), "%d", &attemptID)
+		if err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_attempt_id",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		page, err := GetQuestionPage(attemptID)
+		if errors.Is(err, ErrAttemptNotFound) {
+			c.JSON(404, APIResponse[any]{
+				Status:  "error",
+				Message: "attempt_not_found",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "failed_to_get_question_page",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[*QuestionPage]{
+			Status:  "success",
+			Message: "question_page_retrieved",
+			Error:   "",
+			Data:    page,
+		})
+	})
+
+	// Get pending attempts that need answers
+	quizGroup.GET("/pending", func(c *gin.Context) {
+		attempts, err := GetPendingAttempts()
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "failed_to_get_pending_attempts",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[[]QuizAttempt]{
+			Status:  "success",
+			Message: "pending_attempts_retrieved",
+			Error:   "",
+			Data:    attempts,
+		})
+	})
+
+	// Submit answer for a specific session
+	quizGroup.POST("/sessions/:id/answer", RequirePermission(PermissionAnswer), LimitFailedAttempts(LockoutOptions{
+		Scope: "answer",
+		// Per operator, so one operator's typos do not lock the session for
+		// everyone else working on it
+		Target: func(c *gin.Context, body []byte) string {
+			return currentOperator(c).Username + ":" + c.Param("id")
+		},
+	}), Idempotent(IdempotencyOptions{
+		Scope: func(c *gin.Context) string { return "answer:" + c.Param("id") },
+	}), func(c *gin.Context) {
+		var sessionID uint
+		_, err := fmt.Sscanf(c.Param("id"), "%d", &sessionID)
+		if err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_session_id",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		audit := newAuditContext(c, currentOperator(c).Username)
+
+		var answerReq struct {
+			Answer    json.RawMessage `json:"answer"` // kept as sent, so numbers and key order survive
+			Mode      AnswerMode      `json:"mode" binding:"omitempty,oneof=value raw"`
+			SubmitURL string          `json:"submitUrl,omitempty"`
+			Password  string          `json:"password"`
+		}
+
+		if err := c.ShouldBindJSON(&answerReq); err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_answer_format",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		// Validate password
+		if !secretsEqual(answerReq.Password, cfg.QuizAttemptPassword) {
+			c.JSON(403, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_password",
+				Error:   "Invalid quiz attempt password",
+				Data:    nil,
+			})
+			return
+		}
+
+		// Clients from before value mode send the whole body
+		if answerReq.Mode == "" {
+			answerReq.Mode = AnswerModeRaw
+		}
+
+		response, err := SubmitManualAnswer(sessionID, answerReq.Answer, answerReq.Mode, answerReq.SubmitURL, audit)
+
+		if errors.Is(err, ErrSubmitURLRequired) {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "submit_url_required",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if errors.Is(err, ErrSubmitURLNotAllowed) {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "submit_url_not_allowed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if errors.Is(err, ErrAttemptInFlight) {
+			c.JSON(409, APIResponse[any]{
+				Status:  "error",
+				Message: "attempt_in_flight",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		var transitionErr *TransitionError
+		if errors.As(err, &transitionErr) {
+			c.JSON(409, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_state_transition",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "failed_to_submit_answer",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[*QuizResponse]{
+			Status:  "success",
+			Message: "answer_submitted",
+			Error:   "",
+			Data:    response,
+		})
+	})
+
+	// Initial submission endpoint
+	r.POST("/submit-initial", EnsureAuthenticated(), RequirePermission(PermissionAnswer), func(c *gin.Context) {
+		type InitialSubmissionBody struct {
+			Email  string `json:"email" binding:"required"`
+			Secret string `json:"secret" binding:"required"`
+			Answer string `json:"answer" binding:"required"`
+			Source string `json:"source"` // quiz source name, defaults to the active one
+		}
+
+		audit := newAuditContext(c, currentOperator(c).Username)
+
+		var body InitialSubmissionBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_request_body",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		var source *QuizSource
+		var err error
+		if body.Source != "" {
+			source, err = GetQuizSource(body.Source)
+		} else {
+			source, err = GetActiveQuizSource()
+		}
+
+		if errors.Is(err, ErrQuizSourceNotFound) {
+			c.JSON(404, APIResponse[any]{
+				Status:  "error",
+				Message: "quiz_source_not_found",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "quiz_source_lookup_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		response, err := ProcessInitialSubmissionFlow(source, body.Email, body.Secret, body.Answer, audit)
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "initial_submission_failed",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		message := "initial_submission_completed"
+		if response.Correct && response.URL != "" {
+			message = "initial_submission_completed_with_ingest_and_quiz_session"
+		}
+
+		c.JSON(200, APIResponse[*InitialSubmissionResponse]{
+			Status:  "success",
+			Message: message,
+			Error:   "",
+			Data:    response,
+		})
+	})
+
+	// Live updates for the dashboard
+	r.GET("/events", EnsureAuthenticated(), StreamEvents)
+
+	r.GET("/audit", EnsureAuthenticated(), RequirePermission(PermissionAdmin), func(c *gin.Context) {
+		filter, err := parseAuditFilter(c)
+		if err != nil {
+			c.JSON(400, APIResponse[any]{
+				Status:  "error",
+				Message: "invalid_audit_filter",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		page, err := ListAuditEvents(filter)
+		if err != nil {
+			c.JSON(500, APIResponse[any]{
+				Status:  "error",
+				Message: "failed_to_list_audit_events",
+				Error:   err.Error(),
+				Data:    nil,
+			})
+			return
+		}
+
+		c.JSON(200, APIResponse[*AuditPage]{
+			Status:  "success",
+			Message: "audit_events_listed",
+			Error:   "",
+			Data:    page,
+		})
+	})
+
+	return r
+}
+
+// OperatorInfo is returned on login so the dashboard can send the CSRF token.
+type OperatorInfo struct {
+	Username  string       `json:"username"`
+	Role      OperatorRole `json:"role"`
+	CSRFToken string       `json:"csrfToken"`
+}
+
+// setSessionCookie sets (or with maxAge -1, clears) the HTTP-only session
+// cookie. It is marked Secure when the dashboard is served over HTTPS.
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	secure := c.Request.TLS != nil || strings.HasPrefix(AppConfig.PublicBaseURL, "https://")
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(OPERATOR_SESSION_COOKIE, token, maxAge, "/", "", secure, true)
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func ingestBody(email, url string) TaskRequest {
+	return TaskRequest{Email: email, Secret: testSecret, Url: url}
+}
+
+func acceptBody(id uint, password string) map[string]any {
+	return map[string]any{"id": id, "password": password}
+}
+
+// createRunningSession ingests a task for the grader's first question and
+// accepts it, returning the new session.
+func createRunningSession(t *testing.T, r http.Handler, grader *MockGrader, email string) QuizSession {
+	t.Helper()
+
+	doRequest(t, r, "POST", "/ingest", ingestBody(email, grader.QuestionURL(0))).expect(t, 200, "ingest_created")
+
+	var ingest Ingests
+	if err := DB.Where("email = ?", email).First(&ingest).Error; err != nil {
+		t.Fatalf("find ingest: %v", err)
+	}
+
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(ingest.ID, testAcceptPassword)).
+		expect(t, 200, "ingest_accepted_and_quiz_started")
+
+	var session QuizSession
+	if err := DB.Where("ingest_id = ?", ingest.ID).First(&session).Error; err != nil {
+		t.Fatalf("find session: %v", err)
+	}
+	return session
+}
+
+func answer(t *testing.T, r http.Handler, grader *MockGrader, session QuizSession, step int, value any) testResponse {
+	t.Helper()
+
+	return doRequest(t, r, "POST", fmt.Sprintf("/quiz/sessions/%d/answer", session.ID), map[string]any{
+		"password":  testAttemptPass,
+		"submitUrl": grader.SubmitURL(),
+		"answer": AnswerSubmission{
+			Email:  session.Email,
+			Secret: testSecret,
+			URL:    grader.QuestionURL(step),
+			Answer: value,
+		},
+	})
+}
+
+func TestIngestRejectsWrongSecret(t *testing.T) {
+	r := newTestRouter(t)
+
+	body := TaskRequest{Email: "a@example.com", Secret: "wrong", Url: "https://example.com/q1"}
+	res := doRequest(t, r, "POST", "/ingest", body).expect(t, 403, "unauthorized")
+	if res.Error != "invalid_secret" {
+		t.Errorf("error = %q, want invalid_secret", res.Error)
+	}
+
+	doRequest(t, r, "POST", "/ingest", "{not json").expect(t, 400, "invalid_request_body")
+
+	var count int64
+	DB.Model(&Ingests{}).Count(&count)
+	if count != 0 {
+		t.Errorf("rejected requests created %d ingests", count)
+	}
+}
+
+func TestIngestCreateAndList(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+
+	body := ingestBody("a@example.com", "https://example.com/q1")
+	doRequest(t, r, "POST", "/ingest", body).expect(t, 200, "ingest_created")
+
+	// A retry of the same task replays instead of creating a second ingest
+	replay := doRequest(t, r, "POST", "/ingest", body).expect(t, 200, "ingest_created")
+	if replay.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry was not replayed")
+	}
+
+	var ingests []Ingests
+	doRequest(t, r, "GET", "/ingest", nil).expect(t, 200, "ingests_listed").decode(t, &ingests)
+
+	if len(ingests) != 1 {
+		t.Fatalf("listed %d ingests, want 1", len(ingests))
+	}
+	ingest := ingests[0]
+	if ingest.Email != "a@example.com" || ingest.URL != "https://example.com/q1" || ingest.Status != IngestStatusPending {
+		t.Errorf("unexpected ingest %+v", ingest)
+	}
+	if ingest.QuizSourceID == nil {
+		t.Errorf("ingest was not attributed to the active quiz source")
+	}
+	if !ingest.Deadline.After(ingest.CreatedAt) {
+		t.Errorf("deadline %v is not after creation %v", ingest.Deadline, ingest.CreatedAt)
+	}
+}
+
+func TestNotificationAccept(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", "https://example.com/q1")).expect(t, 200, "ingest_created")
+
+	doRequest(t, r, "POST", "/ingest/notification-accept", map[string]any{"id": "abc", "password": testAcceptPassword}).
+		expect(t, 400, "invalid_request_body")
+
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, "wrong")).
+		expect(t, 401, "invalid_password")
+	if ingest := mustFindIngest(t, 1); ingest.Status != IngestStatusPending {
+		t.Fatalf("wrong password moved ingest to %q", ingest.Status)
+	}
+
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword)).
+		expect(t, 200, "ingest_accepted_and_quiz_started")
+	if ingest := mustFindIngest(t, 1); ingest.Status != IngestStatusRunning {
+		t.Errorf("ingest status = %q, want %q", ingest.Status, IngestStatusRunning)
+	}
+
+	// Accepting twice is an invalid transition
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword)).
+		expect(t, 409, "invalid_state_transition")
+
+	var page QuizSessionPage
+	doRequest(t, r, "GET", "/quiz/sessions", nil).expect(t, 200, "sessions_retrieved").decode(t, &page)
+	sessions := page.Sessions
+	if len(sessions) != 1 || sessions[0].IngestID != 1 || sessions[0].Status != QuizSessionStatusWaiting {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+
+	var pending []QuizAttempt
+	doRequest(t, r, "GET", "/quiz/pending", nil).expect(t, 200, "pending_attempts_retrieved").decode(t, &pending)
+	if len(pending) != 1 || pending[0].SessionID != sessions[0].ID || pending[0].URL != "https://example.com/q1" {
+		t.Fatalf("unexpected pending attempts %+v", pending)
+	}
+}
+
+func TestAnswerValidation(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{})
+	session := createRunningSession(t, r, grader, "a@example.com")
+	path := fmt.Sprintf("/quiz/sessions/%d/answer", session.ID)
+
+	doRequest(t, r, "POST", "/quiz/sessions/abc/answer", map[string]any{"answer": 1}).
+		expect(t, 400, "invalid_session_id")
+
+	doRequest(t, r, "POST", path, map[string]any{"password": testAttemptPass, "answer": 1}).
+		expect(t, 400, "submit_url_required")
+
+	doRequest(t, r, "POST", path, map[string]any{
+		"password":  "wrong",
+		"submitUrl": grader.SubmitURL(),
+		"answer":    1,
+	}).expect(t, 403, "invalid_password")
+
+	if session := mustFindSession(t, session.ID); session.Status != QuizSessionStatusWaiting {
+		t.Errorf("rejected answers moved session to %q", session.Status)
+	}
+
+	// Nothing was claimed, so nothing was recorded either
+	history, err := GetStatusHistory(STATUS_ENTITY_QUIZ_SESSION, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 {
+		t.Errorf("rejected answers added status history %+v", history)
+	}
+	var audited int64
+	DB.Model(&AuditEvent{}).Where("action = ?", AUDIT_ACTION_SUBMIT_ANSWER).Count(&audited)
+	if audited != 0 {
+		t.Errorf("rejected answers recorded %d audit events", audited)
+	}
+}
+
+func TestAnswerRetryChain(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{Questions: []MockQuestion{
+		{Question: "2 + 2?", Answer: 4},
+		{Question: "Capital of France?", Answer: "Paris"},
+	}})
+	session := createRunningSession(t, r, grader, "a@example.com")
+
+	var response QuizResponse
+	answer(t, r, grader, session, 0, 5).expect(t, 200, "answer_submitted").decode(t, &response)
+	if response.Correct || response.URL != "" || response.Reason != "wrong answer" {
+		t.Fatalf("wrong answer got %+v", response)
+	}
+
+	// The retry keeps the URL and deadline of the first attempt
+	attempts, err := GetQuizAttempts(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 || attempts[1].URL != attempts[0].URL || !attempts[1].Deadline.Equal(attempts[0].Deadline) {
+		t.Fatalf("unexpected attempts after retry %+v", attempts)
+	}
+
+	response = QuizResponse{}
+	answer(t, r, grader, session, 0, 4).expect(t, 200, "answer_submitted").decode(t, &response)
+	if !response.Correct || response.URL != grader.QuestionURL(1) {
+		t.Fatalf("correct answer got %+v", response)
+	}
+	if session := mustFindSession(t, session.ID); session.CurrentURL != grader.QuestionURL(1) || session.Status != QuizSessionStatusWaiting {
+		t.Fatalf("session not advanced: %+v", session)
+	}
+
+	response = QuizResponse{}
+	answer(t, r, grader, session, 1, "paris").expect(t, 200, "answer_submitted").decode(t, &response)
+	if !response.Correct || response.URL != "" {
+		t.Fatalf("last answer got %+v", response)
+	}
+
+	if session := mustFindSession(t, session.ID); session.Status != QuizSessionStatusCompleted {
+		t.Errorf("session status = %q, want %q", session.Status, QuizSessionStatusCompleted)
+	}
+	if ingest := mustFindIngest(t, session.IngestID); ingest.Status != IngestStatusCompleted {
+		t.Errorf("ingest status = %q, want %q", ingest.Status, IngestStatusCompleted)
+	}
+
+	var pending []QuizAttempt
+	doRequest(t, r, "GET", "/quiz/pending", nil).expect(t, 200, "pending_attempts_retrieved").decode(t, &pending)
+	if len(pending) != 0 {
+		t.Errorf("completed quiz still has %d pending attempts", len(pending))
+	}
+}
+
+func TestAnswerRecordFailureReleasesSession(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{Questions: DefaultMockQuestions(1)})
+	session := createRunningSession(t, r, grader, "a@example.com")
+
+	// Completing the ingest fails after the session has moved to completed
+	failIngestUpdates := func(tx *gorm.DB) {
+		if tx.Statement.Table == "ingests" {
+			tx.AddError(errors.New("ingest update failed"))
+		}
+	}
+	if err := DB.Callback().Update().Before("gorm:update").Register("test:fail_ingest_updates", failIngestUpdates); err != nil {
+		t.Fatal(err)
+	}
+	answer(t, r, grader, session, 0, 13).expect(t, 500, "failed_to_submit_answer")
+	DB.Callback().Update().Remove("test:fail_ingest_updates")
+
+	if session := mustFindSession(t, session.ID); session.Status != QuizSessionStatusWaiting {
+		t.Fatalf("session status = %q after a failed answer, want %q", session.Status, QuizSessionStatusWaiting)
+	}
+	if attempt := mustFindAttempts(t, session.ID)[0]; attempt.ClaimedAt != nil || attempt.Answer != "" {
+		t.Fatalf("failed answer left attempt %+v", attempt)
+	}
+
+	// The grader already finished its quiz, but the attempt can be answered
+	// again rather than being stuck in flight
+	answer(t, r, grader, session, 0, 13).expect(t, 200, "answer_submitted")
+}
+
+func TestAnswerExpiredAttempt(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{})
+	session := createRunningSession(t, r, grader, "a@example.com")
+
+	backdateAttempts(t)
+
+	res := answer(t, r, grader, session, 0, 13).expect(t, 500, "failed_to_submit_answer")
+	if res.Error == "" {
+		t.Errorf("expired attempt failed without an error message")
+	}
+
+	SweepJob()
+
+	if session := mustFindSession(t, session.ID); session.Status != QuizSessionStatusFailed {
+		t.Errorf("session status = %q, want %q", session.Status, QuizSessionStatusFailed)
+	}
+	if ingest := mustFindIngest(t, session.IngestID); ingest.Status != IngestStatusFailed || ingest.FailureReason != FAILURE_REASON_DEADLINE_EXPIRED {
+		t.Errorf("ingest = %q (%s), want %q (%s)", ingest.Status, ingest.FailureReason, IngestStatusFailed, FAILURE_REASON_DEADLINE_EXPIRED)
+	}
+
+	var pending []QuizAttempt
+	doRequest(t, r, "GET", "/quiz/pending", nil).expect(t, 200, "pending_attempts_retrieved").decode(t, &pending)
+	if len(pending) != 0 {
+		t.Errorf("expired attempts still pending: %+v", pending)
+	}
+}
+
+func TestAnswerValueMode(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{Questions: DefaultMockQuestions(2), Secret: testSecret})
+	useGraderSource(t, grader)
+	session := createRunningSession(t, r, grader, "a@example.com")
+	path := fmt.Sprintf("/quiz/sessions/%d/answer", session.ID)
+
+	// The secret is never sent off the quiz source's host
+	other := newTestGrader(t, MockGraderOptions{})
+	doRequest(t, r, "POST", path, map[string]any{
+		"password":  testAttemptPass,
+		"submitUrl": other.SubmitURL(),
+		"mode":      AnswerModeValue,
+		"answer":    13,
+	}).expect(t, 400, "submit_url_not_allowed")
+	if session := mustFindSession(t, session.ID); session.Status != QuizSessionStatusWaiting {
+		t.Fatalf("rejected answer moved session to %q", session.Status)
+	}
+
+	// Only the value: the grader's secret check passes with the stored secret
+	var response QuizResponse
+	doRequest(t, r, "POST", path, map[string]any{
+		"password":  testAttemptPass,
+		"submitUrl": grader.SubmitURL(),
+		"mode":      AnswerModeValue,
+		"answer":    13,
+	}).expect(t, 200, "answer_submitted").decode(t, &response)
+	if !response.Correct || response.URL != grader.QuestionURL(1) {
+		t.Fatalf("value answer got %+v", response)
+	}
+
+	// Without a mode the body is posted as given, here without the secret
+	response = QuizResponse{}
+	doRequest(t, r, "POST", path, map[string]any{
+		"password":  testAttemptPass,
+		"submitUrl": grader.SubmitURL(),
+		"answer":    AnswerSubmission{Email: session.Email, URL: grader.QuestionURL(1), Answer: 23},
+	}).expect(t, 200, "answer_submitted").decode(t, &response)
+	if response.Correct || response.Reason != "invalid secret" {
+		t.Fatalf("raw answer without secret got %+v", response)
+	}
+
+	answer(t, r, grader, session, 1, 23).expect(t, 200, "answer_submitted")
+
+	attempts, err := GetQuizAttempts(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts[0].Answer != "13" {
+		t.Errorf("value answer stored as %q", attempts[0].Answer)
+	}
+	if stored := attempts[2].Answer; strings.Contains(stored, testSecret) || !strings.Contains(stored, `"answer":23`) {
+		t.Errorf("raw answer stored as %q", stored)
+	}
+
+	doRequest(t, r, "POST", path, map[string]any{"password": testAttemptPass, "mode": "wrapped", "answer": 1}).
+		expect(t, 400, "invalid_answer_format")
+}
+
+func TestAnswerValueModeWithoutQuizSource(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{Questions: DefaultMockQuestions(2), Secret: testSecret})
+	session := createRunningSession(t, r, grader, "a@example.com")
+
+	// An ingest whose source was deleted falls back to the configured
+	// grader. The page is moved off the grader's host so only that allows it.
+	AppConfig.GraderSubmitURL = grader.SubmitURL()
+	if err := DB.Model(&Ingests{}).Where("id = ?", session.IngestID).Update("quiz_source_id", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Model(&QuizAttempt{}).Where("session_id = ?", session.ID).Update("url", "https://quiz.example.com/q1").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	other := newTestGrader(t, MockGraderOptions{})
+	doRequest(t, r, "POST", fmt.Sprintf("/quiz/sessions/%d/answer", session.ID), map[string]any{
+		"password":  testAttemptPass,
+		"submitUrl": other.SubmitURL(),
+		"mode":      AnswerModeValue,
+		"answer":    13,
+	}).expect(t, 400, "submit_url_not_allowed")
+
+	doRequest(t, r, "POST", fmt.Sprintf("/quiz/sessions/%d/answer", session.ID), map[string]any{
+		"password":  testAttemptPass,
+		"submitUrl": grader.SubmitURL(),
+		"mode":      AnswerModeValue,
+		"answer":    13,
+	}).expect(t, 200, "answer_submitted")
+}
+
+func TestAnswerValueStoredAsSent(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{Secret: testSecret})
+	useGraderSource(t, grader)
+	session := createRunningSession(t, r, grader, "a@example.com")
+
+	// Not a raw body, so nothing is redacted, reordered or rounded
+	const value = `{"z":1,"secret":"part of the answer","n":12345678901234567890}`
+	doRequest(t, r, "POST", fmt.Sprintf("/quiz/sessions/%d/answer", session.ID), map[string]any{
+		"password":  testAttemptPass,
+		"submitUrl": grader.SubmitURL(),
+		"mode":      AnswerModeValue,
+		"answer":    json.RawMessage(value),
+	}).expect(t, 200, "answer_submitted")
+
+	if stored := mustFindAttempts(t, session.ID)[0].Answer; stored != value {
+		t.Errorf("value answer stored as %q, want %q", stored, value)
+	}
+}
+
+func TestSubmitInitial(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{Secret: testSecret})
+	useGraderSource(t, grader)
+
+	doRequest(t, r, "POST", "/submit-initial", map[string]any{"email": "a@example.com"}).
+		expect(t, 400, "invalid_request_body")
+
+	doRequest(t, r, "POST", "/submit-initial", map[string]any{
+		"email": "a@example.com", "secret": testSecret, "answer": "start", "source": "missing",
+	}).expect(t, 404, "quiz_source_not_found")
+
+	// The grader rejects a bad secret; nothing is ingested
+	doRequest(t, r, "POST", "/submit-initial", map[string]any{
+		"email": "a@example.com", "secret": "wrong", "answer": "start",
+	}).expect(t, 500, "initial_submission_failed")
+
+	var count int64
+	DB.Model(&Ingests{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("rejected initial submission created %d ingests", count)
+	}
+
+	var response InitialSubmissionResponse
+
+	doRequest(t, r, "POST", "/submit-initial", map[string]any{
+		"email": "a@example.com", "secret": testSecret, "answer": "start",
+	}).expect(t, 200, "initial_submission_completed_with_ingest_and_quiz_session").decode(t, &response)
+	if !response.Correct || response.URL != grader.QuestionURL(0) {
+		t.Fatalf("initial submission got %+v", response)
+	}
+
+	var ingests []Ingests
+	DB.Find(&ingests)
+	if len(ingests) != 1 || ingests[0].URL != grader.QuestionURL(0) || ingests[0].Status != IngestStatusRunning {
+		t.Fatalf("unexpected ingests %+v", ingests)
+	}
+
+	var session QuizSession
+	if err := DB.Where("ingest_id = ?", ingests[0].ID).First(&session).Error; err != nil {
+		t.Fatalf("no session for the ingest: %v", err)
+	}
+
+	// The session can be answered through to completion
+	for step, value := range []any{13, 23, 33} {
+		var response QuizResponse
+		answer(t, r, grader, session, step, value).expect(t, 200, "answer_submitted").decode(t, &response)
+		if !response.Correct {
+			t.Fatalf("step %d rejected: %+v", step+1, response)
+		}
+	}
+	if session := mustFindSession(t, session.ID); session.Status != QuizSessionStatusCompleted {
+		t.Errorf("session status = %q, want %q", session.Status, QuizSessionStatusCompleted)
+	}
+}
+
+// TestMockGraderKickOff rehearses the flow the way the live grader drives
+// it: the grader posts to /ingest over HTTP.
+func TestMockGraderKickOff(t *testing.T) {
+	r := newTestRouter(t)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	grader := newTestGrader(t, MockGraderOptions{})
+	if err := grader.KickOff(server.URL+"/ingest", "a@example.com", testSecret); err != nil {
+		t.Fatalf("KickOff: %v", err)
+	}
+	if err := grader.KickOff(server.URL+"/ingest", "b@example.com", "wrong"); err == nil {
+		t.Errorf("KickOff with a wrong secret succeeded")
+	}
+
+	ingest := mustFindIngest(t, 1)
+	if ingest.Email != "a@example.com" || ingest.URL != grader.QuestionURL(0) {
+		t.Fatalf("unexpected ingest %+v", ingest)
+	}
+}
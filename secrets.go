@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Participant secrets are stored with envelope encryption: each secret is
+// sealed with its own random data key, and the data key is sealed with a
+// key from SECRET_ENCRYPTION_KEYS. The stored value is
+// "<sealed data key>.<sealed secret>" and the row's secret_key_id names the
+// key that sealed the data key, so rotating keys only re-seals data keys.
+
+const (
+	ENCRYPTION_KEY_SIZE = 32 // AES-256
+
+	ROTATE_BATCH_SIZE = 100
+)
+
+var ErrUnknownEncryptionKey = errors.New("unknown encryption key")
+
+// EncryptionKey is a key-encryption key. The first configured key seals new
+// secrets; the others are kept to open secrets sealed before a rotation.
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// parseEncryptionKeys parses a comma-separated list of id:base64-key pairs.
+func parseEncryptionKeys(value string) ([]EncryptionKey, error) {
+	var keys []EncryptionKey
+	seen := map[string]bool{}
+
+	for _, item := range splitList(value) {
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("expected id:base64-key, got %q", item)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+		seen[id] = true
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64: %v", id, err)
+		}
+		if len(key) != ENCRYPTION_KEY_SIZE {
+			return nil, fmt.Errorf("key %q must be %d bytes, got %d", id, ENCRYPTION_KEY_SIZE, len(key))
+		}
+
+		keys = append(keys, EncryptionKey{ID: id, Key: key})
+	}
+
+	return keys, nil
+}
+
+func findEncryptionKey(id string) (*EncryptionKey, error) {
+	for i := range AppConfig.EncryptionKeys {
+		if AppConfig.EncryptionKeys[i].ID == id {
+			return &AppConfig.EncryptionKeys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownEncryptionKey, id)
+}
+
+func activeEncryptionKey() (*EncryptionKey, error) {
+	if len(AppConfig.EncryptionKeys) == 0 {
+		return nil, fmt.Errorf("SECRET_ENCRYPTION_KEYS is not configured")
+	}
+	return &AppConfig.EncryptionKeys[0], nil
+}
+
+// sealSecret encrypts a secret under a new data key, returning the stored
+// value and the ID of the key that sealed the data key.
+func sealSecret(plaintext string) (string, string, error) {
+	kek, err := activeEncryptionKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	dataKey := make([]byte, ENCRYPTION_KEY_SIZE)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", "", fmt.Errorf("failed to generate data key: %v", err)
+	}
+
+	sealedKey, err := gcmSeal(kek.Key, dataKey)
+	if err != nil {
+		return "", "", err
+	}
+	sealedSecret, err := gcmSeal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", "", err
+	}
+
+	return sealedKey + "." + sealedSecret, kek.ID, nil
+}
+
+// openSecret decrypts a value stored by sealSecret. Rows written before
+// encryption have no key ID and are returned as they are.
+func openSecret(stored, keyID string) (string, error) {
+	if keyID == "" {
+		return stored, nil
+	}
+
+	dataKey, sealedSecret, err := openDataKey(stored, keyID)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := gcmOpen(dataKey, sealedSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// resealSecret moves a stored secret to the active key. Only the data key
+// is re-encrypted; plaintext rows are sealed from scratch.
+func resealSecret(stored, keyID string) (string, string, error) {
+	if keyID == "" {
+		return sealSecret(stored)
+	}
+
+	kek, err := activeEncryptionKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	dataKey, sealedSecret, err := openDataKey(stored, keyID)
+	if err != nil {
+		return "", "", err
+	}
+
+	sealedKey, err := gcmSeal(kek.Key, dataKey)
+	if err != nil {
+		return "", "", err
+	}
+	return sealedKey + "." + sealedSecret, kek.ID, nil
+}
+
+func openDataKey(stored, keyID string) ([]byte, string, error) {
+	kek, err := findEncryptionKey(keyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sealedKey, sealedSecret, ok := strings.Cut(stored, ".")
+	if !ok {
+		return nil, "", fmt.Errorf("malformed encrypted secret")
+	}
+
+	dataKey, err := gcmOpen(kek.Key, sealedKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt data key with key %q: %v", keyID, err)
+	}
+	return dataKey, sealedSecret, nil
+}
+
+// gcmSeal encrypts with AES-GCM and returns base64(nonce || ciphertext).
+func gcmSeal(key, plaintext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	return base64.RawStdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func gcmOpen(key []byte, sealed string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("malformed ciphertext")
+	}
+
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// DecryptSecret returns the participant secret of a quiz session.
+func (s *QuizSession) DecryptSecret() (string, error) {
+	return openSecret(s.Secret, s.SecretKeyID)
+}
+
+// redactRawRequest drops the secret from a raw JSON request before it is
+// stored for reference.
+func redactRawRequest(raw []byte) string {
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return ""
+	}
+	delete(fields, "secret")
+
+	redacted, _ := json.Marshal(fields)
+	return string(redacted)
+}
+
+// RotateSecretKeys re-seals every stored secret that is not under the
+// active key, including rows written before encryption. It returns how
+// many ingests and quiz sessions were updated.
+func RotateSecretKeys() (int, int, error) {
+	kek, err := activeEncryptionKey()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ingests, err := rotateTable(&Ingests{}, kek.ID)
+	if err != nil {
+		return ingests, 0, fmt.Errorf("failed to rotate ingest secrets: %v", err)
+	}
+
+	sessions, err := rotateTable(&QuizSession{}, kek.ID)
+	if err != nil {
+		return ingests, sessions, fmt.Errorf("failed to rotate quiz session secrets: %v", err)
+	}
+
+	return ingests, sessions, nil
+}
+
+// CountStaleSecrets counts stored secrets that RotateSecretKeys would
+// re-seal.
+func CountStaleSecrets() (int64, error) {
+	kek, err := activeEncryptionKey()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, model := range []any{&Ingests{}, &QuizSession{}} {
+		var count int64
+		if err := DB.Model(model).Where("secret_key_id <> ? AND secret <> ''", kek.ID).Count(&count).Error; err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+type encryptedSecretRow struct {
+	ID          uint
+	Secret      string
+	SecretKeyID string
+}
+
+// rotateTable re-seals the secrets of one table in batches, each batch in
+// its own transaction.
+func rotateTable(model any, activeKeyID string) (int, error) {
+	rotated := 0
+
+	for {
+		var rows []encryptedSecretRow
+		err := DB.Model(model).
+			Select("id", "secret", "secret_key_id").
+			Where("secret_key_id <> ? AND secret <> ''", activeKeyID).
+			Order("id ASC").
+			Limit(ROTATE_BATCH_SIZE).
+			Find(&rows).Error
+		if err != nil {
+			return rotated, err
+		}
+		if len(rows) == 0 {
+			return rotated, nil
+		}
+
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				secret, keyID, err := resealSecret(row.Secret, row.SecretKeyID)
+				if err != nil {
+					return fmt.Errorf("row %d: %w", row.ID, err)
+				}
+
+				err = tx.Model(model).Where("id = ?", row.ID).UpdateColumns(map[string]any{
+					"secret":        secret,
+					"secret_key_id": keyID,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return rotated, err
+		}
+
+		rotated += len(rows)
+	}
+}
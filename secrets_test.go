@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIngestSecretIsEncrypted(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", "https://example.com/q1")).expect(t, 200, "ingest_created")
+
+	ingest := mustFindIngest(t, 1)
+	if ingest.SecretKeyID != "a" || ingest.Secret == "" || strings.Contains(ingest.Secret, testSecret) {
+		t.Errorf("ingest secret stored as %q under key %q", ingest.Secret, ingest.SecretKeyID)
+	}
+	if strings.Contains(ingest.Raw, testSecret) || !strings.Contains(ingest.Raw, "a@example.com") {
+		t.Errorf("raw request not redacted: %s", ingest.Raw)
+	}
+
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword)).
+		expect(t, 200, "ingest_accepted_and_quiz_started")
+
+	session := mustFindSession(t, 1)
+	if secret, err := session.DecryptSecret(); err != nil || secret != testSecret {
+		t.Errorf("DecryptSecret() = %q, %v", secret, err)
+	}
+
+	res := doRequest(t, r, "GET", "/ingest", nil).expect(t, 200, "ingests_listed")
+	if strings.Contains(string(res.Data), testSecret) {
+		t.Errorf("ingest list leaks the secret: %s", res.Data)
+	}
+}
+
+func TestRotateSecretKeys(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+
+	doRequest(t, r, "POST", "/ingest", ingestBody("a@example.com", "https://example.com/q1")).expect(t, 200, "ingest_created")
+	doRequest(t, r, "POST", "/ingest/notification-accept", acceptBody(1, testAcceptPassword)).
+		expect(t, 200, "ingest_accepted_and_quiz_started")
+
+	// A row written before secrets were encrypted
+	legacy := Ingests{Email: "b@example.com", Secret: "legacy-secret", Status: IngestStatusPending}
+	if err := DB.Create(&legacy).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := parseEncryptionKeys(testKeyB + "," + testKeyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AppConfig.EncryptionKeys = keys
+
+	if count, err := CountStaleSecrets(); err != nil || count != 3 {
+		t.Fatalf("CountStaleSecrets() = %d, %v, want 3", count, err)
+	}
+
+	ingests, sessions, err := RotateSecretKeys()
+	if err != nil || ingests != 2 || sessions != 1 {
+		t.Fatalf("RotateSecretKeys() = %d, %d, %v, want 2, 1", ingests, sessions, err)
+	}
+
+	// The old key is no longer needed
+	AppConfig.EncryptionKeys = keys[:1]
+
+	for id, want := range map[uint]string{1: testSecret, legacy.ID: "legacy-secret"} {
+		ingest := mustFindIngest(t, id)
+		secret, err := openSecret(ingest.Secret, ingest.SecretKeyID)
+		if ingest.SecretKeyID != "b" || err != nil || secret != want {
+			t.Errorf("ingest %d: key %q, secret %q, %v", id, ingest.SecretKeyID, secret, err)
+		}
+	}
+
+	session := mustFindSession(t, 1)
+	if secret, err := session.DecryptSecret(); session.SecretKeyID != "b" || err != nil || secret != testSecret {
+		t.Errorf("session: key %q, secret %q, %v", session.SecretKeyID, secret, err)
+	}
+
+	if ingests, sessions, err := RotateSecretKeys(); err != nil || ingests+sessions != 0 {
+		t.Errorf("second rotation re-sealed %d, %d rows (%v)", ingests, sessions, err)
+	}
+}
+
+func TestOpenSecretWithUnknownKey(t *testing.T) {
+	newTestRouter(t)
+
+	sealed, _, err := sealSecret("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openSecret(sealed, "retired"); !errors.Is(err, ErrUnknownEncryptionKey) {
+		t.Errorf("got %v, want ErrUnknownEncryptionKey", err)
+	}
+}
+
+func TestParseEncryptionKeys(t *testing.T) {
+	for _, value := range []string{
+		"MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", // no id
+		"a:not-base64!",
+		"a:c2hvcnQ=",              // too short
+		testKeyA + "," + testKeyA, // duplicate id
+	} {
+		if _, err := parseEncryptionKeys(value); err == nil {
+			t.Errorf("parseEncryptionKeys(%q) succeeded", value)
+		}
+	}
+
+	keys, err := parseEncryptionKeys(testKeyB + ", " + testKeyA)
+	if err != nil || len(keys) != 2 || keys[0].ID != "b" || keys[1].ID != "a" {
+		t.Errorf("parseEncryptionKeys() = %+v, %v", keys, err)
+	}
+}
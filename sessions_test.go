@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func listSessions(t *testing.T, r http.Handler, query string) QuizSessionPage {
+	t.Helper()
+
+	var page QuizSessionPage
+	doRequest(t, r, "GET", "/quiz/sessions?"+query, nil).expect(t, 200, "sessions_retrieved").decode(t, &page)
+	return page
+}
+
+func sessionIDs(page QuizSessionPage) []uint {
+	ids := []uint{}
+	for _, session := range page.Sessions {
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+// countQueries counts the SELECTs run against a table until the test ends.
+func countQueries(t *testing.T, table string) *int {
+	t.Helper()
+
+	count := 0
+	if err := DB.Callback().Query().After("gorm:query").Register("test:count_queries", func(tx *gorm.DB) {
+		if tx.Statement.Table == table {
+			count++
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DB.Callback().Query().Remove("test:count_queries") })
+	return &count
+}
+
+func TestListSessionsIncludeAttempts(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{Questions: DefaultMockQuestions(2)})
+
+	first := createRunningSession(t, r, grader, "a@example.com")
+	createRunningSession(t, r, grader, "b@example.com")
+	answer(t, r, grader, first, 0, 1).expect(t, 200, "answer_submitted")
+	answer(t, r, grader, first, 0, 13).expect(t, 200, "answer_submitted")
+
+	queries := countQueries(t, "quiz_attempts")
+	page := listSessions(t, r, "include=attempts")
+	if *queries != 1 {
+		t.Errorf("attempts were loaded with %d queries, want 1", *queries)
+	}
+
+	if ids := sessionIDs(page); fmt.Sprint(ids) != "[2 1]" || page.NextCursor != nil {
+		t.Fatalf("unexpected page %v, next %v", ids, page.NextCursor)
+	}
+	if attempts := page.Sessions[0].Attempts; len(attempts) != 1 || attempts[0].SessionID != 2 {
+		t.Errorf("unexpected attempts for session 2: %+v", attempts)
+	}
+
+	// Wrong answer, its retry, then the next question, in creation order
+	attempts := page.Sessions[1].Attempts
+	if len(attempts) != 3 || *attempts[0].Correct || !*attempts[1].Correct || attempts[2].URL != grader.QuestionURL(1) {
+		t.Fatalf("unexpected attempts for session 1: %+v", attempts)
+	}
+
+	var raw struct {
+		Sessions []map[string]any `json:"sessions"`
+	}
+	doRequest(t, r, "GET", "/quiz/sessions", nil).expect(t, 200, "sessions_retrieved").decode(t, &raw)
+	if _, ok := raw.Sessions[0]["attempts"]; ok {
+		t.Errorf("attempts returned without include=attempts")
+	}
+}
+
+func TestListSessionsFiltersAndPaging(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+	grader := newTestGrader(t, MockGraderOptions{})
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"} {
+		createRunningSession(t, r, grader, email)
+	}
+
+	// Sessions 1 and 2 are older and have finished
+	past := time.Now().Add(-48 * time.Hour)
+	if err := DB.Model(&QuizSession{}).Where("id IN ?", []uint{1, 2}).
+		Updates(map[string]any{"created_at": past, "status": QuizSessionStatusCompleted}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Newest first, two at a time
+	var seen []uint
+	query := "limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("paging did not end, seen %v", seen)
+		}
+		page := listSessions(t, r, query)
+		seen = append(seen, sessionIDs(page)...)
+		if page.NextCursor == nil {
+			break
+		}
+		query = fmt.Sprintf("limit=2&cursor=%d", *page.NextCursor)
+	}
+	if fmt.Sprint(seen) != "[5 4 3 2 1]" {
+		t.Errorf("newest first paged through %v", seen)
+	}
+
+	page := listSessions(t, r, "order=asc&limit=2&cursor=2")
+	if ids := sessionIDs(page); fmt.Sprint(ids) != "[3 4]" || page.NextCursor == nil || *page.NextCursor != 4 {
+		t.Errorf("oldest first after 2 got %v, next %v", ids, page.NextCursor)
+	}
+
+	for query, want := range map[string]string{
+		"status=completed":                     "[2 1]",
+		"status=waiting_for_answer,completed":  "[5 4 3 2 1]",
+		"email=c@example.com":                  "[3]",
+		"email=c@example.com&status=completed": "[]",
+		"from=" + url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339)): "[5 4 3]",
+		"to=" + url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339)):   "[2 1]",
+	} {
+		if ids := sessionIDs(listSessions(t, r, query)); fmt.Sprint(ids) != want {
+			t.Errorf("%s: got %v, want %s", query, ids, want)
+		}
+	}
+}
+
+func TestListSessionsRejectsInvalidFilters(t *testing.T) {
+	r := asOperator(t, newTestRouter(t))
+
+	for _, query := range []string{
+		"status=finished",
+		"from=yesterday",
+		"cursor=-1",
+		"order=sideways",
+		"limit=0",
+		"include=ingest",
+	} {
+		doRequest(t, r, "GET", "/quiz/sessions?"+query, nil).expect(t, 400, "invalid_session_filter")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testSecret         = "test-secret"
+	testAcceptPassword = "accept-pass"
+	testAttemptPass    = "attempt-pass"
+	testOperator       = "operator"
+	testOperatorPass   = "operator-pass"
+
+	testKeyA = "a:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	testKeyB = "b:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+// newTestRouter configures the app against a fresh in-memory SQLite
+// database and returns its router. DB and AppConfig are globals, so tests
+// using it must not run in parallel.
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+
+	for key := range configDefaults {
+		t.Setenv(key, "")
+	}
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("SECRET", testSecret)
+	t.Setenv("INGEST_ACCEPT_PASSWORD", testAcceptPassword)
+	t.Setenv("QUIZ_ATTEMPT_PASSWORD", testAttemptPass)
+	t.Setenv("SECRET_ENCRYPTION_KEYS", testKeyA)
+	// Tests that want question pages fetched turn it on, see useQuestionFetching
+	t.Setenv("QUESTION_FETCH_TIMEOUT", "0s")
+
+	// Each test gets its own named shared-cache database, dropped when the
+	// last connection closes
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	t.Setenv("DATABASE_URL", fmt.Sprintf("sqlite://file:%s?mode=memory&cache=shared", name))
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	AppConfig = cfg
+
+	if err := InitDB(cfg.DatabaseURL); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	// Runs before the database is closed
+	t.Cleanup(questionFetches.Wait)
+
+	if err := EnsureDefaultQuizSource(cfg); err != nil {
+		t.Fatalf("EnsureDefaultQuizSource: %v", err)
+	}
+	if err := InitNotifiers(cfg); err != nil {
+		t.Fatalf("InitNotifiers: %v", err)
+	}
+	if _, err := SaveOperator(testOperator, testOperatorPass, OperatorRoleAdmin); err != nil {
+		t.Fatalf("SaveOperator: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	return NewRouter(cfg)
+}
+
+// login logs in as username and returns the session cookie and CSRF
+// header, ready to pass to doRequest.
+func login(t *testing.T, r http.Handler, username, password string) []string {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(
+		fmt.Sprintf(`{"username": %q, "password": %q}`, username, password)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("login as %s: %d %s", username, w.Code, w.Body.String())
+	}
+
+	var envelope APIResponse[OperatorInfo]
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("login response: %v", err)
+	}
+
+	var cookie string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == OPERATOR_SESSION_COOKIE {
+			cookie = c.Name + "=" + c.Value
+		}
+	}
+	if cookie == "" {
+		t.Fatalf("login set no session cookie")
+	}
+
+	return []string{"Cookie", cookie, CSRF_HEADER, envelope.Data.CSRFToken}
+}
+
+// asOperator wraps the router so every request carries a logged-in
+// operator's session cookie and CSRF token.
+func asOperator(t *testing.T, r http.Handler) http.Handler {
+	t.Helper()
+
+	headers := login(t, r, testOperator, testOperatorPass)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			if req.Header.Get(headers[i]) == "" {
+				req.Header.Set(headers[i], headers[i+1])
+			}
+		}
+		r.ServeHTTP(w, req)
+	})
+}
+
+// newTestGrader starts a mock grader on an httptest server.
+func newTestGrader(t *testing.T, opts MockGraderOptions) *MockGrader {
+	t.Helper()
+
+	var grader *MockGrader
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grader.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	opts.BaseURL = server.URL
+	grader = NewMockGrader(opts)
+	return grader
+}
+
+// useGraderSource saves a quiz source pointing at grader and activates it.
+func useGraderSource(t *testing.T, grader *MockGrader) {
+	t.Helper()
+
+	source := QuizSource{Name: "mock", StartURL: grader.StartURL(), SubmitURL: grader.SubmitURL()}
+	if err := SaveQuizSource(&source); err != nil {
+		t.Fatalf("SaveQuizSource: %v", err)
+	}
+	if err := ActivateQuizSource("mock"); err != nil {
+		t.Fatalf("ActivateQuizSource: %v", err)
+	}
+}
+
+type testResponse struct {
+	Code    int
+	Header  http.Header
+	Message string
+	Error   string
+	Data    json.RawMessage
+}
+
+func doRequest(t *testing.T, r http.Handler, method, path string, body any, headers ...string) testResponse {
+	t.Helper()
+
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("marshal request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var envelope APIResponse[json.RawMessage]
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("%s %s: response is not an API envelope: %v\n%s", method, path, err, w.Body.String())
+	}
+
+	return testResponse{
+		Code:    w.Code,
+		Header:  w.Header(),
+		Message: envelope.Message,
+		Error:   envelope.Error,
+		Data:    envelope.Data,
+	}
+}
+
+// expect fails the test unless the response has the given status and message.
+func (res testResponse) expect(t *testing.T, code int, message string) testResponse {
+	t.Helper()
+
+	if res.Code != code || res.Message != message {
+		t.Fatalf("got %d %q (error %q), want %d %q", res.Code, res.Message, res.Error, code, message)
+	}
+	return res
+}
+
+func (res testResponse) decode(t *testing.T, v any) {
+	t.Helper()
+
+	if err := json.Unmarshal(res.Data, v); err != nil {
+		t.Fatalf("decode response data: %v\n%s", err, res.Data)
+	}
+}
+
+func mustFindIngest(t *testing.T, id uint) Ingests {
+	t.Helper()
+
+	var ingest Ingests
+	if err := DB.First(&ingest, id).Error; err != nil {
+		t.Fatalf("find ingest %d: %v", id, err)
+	}
+	return ingest
+}
+
+func mustFindSession(t *testing.T, id uint) QuizSession {
+	t.Helper()
+
+	var session QuizSession
+	if err := DB.First(&session, id).Error; err != nil {
+		t.Fatalf("find quiz session %d: %v", id, err)
+	}
+	return session
+}
+
+// backdateAttempts moves every open attempt and ingest deadline into the past.
+func backdateAttempts(t *testing.T) {
+	t.Helper()
+
+	past := time.Now().Add(-time.Minute)
+	if err := DB.Model(&QuizAttempt{}).Where("answer = ''").Update("deadline", past).Error; err != nil {
+		t.Fatalf("backdate attempts: %v", err)
+	}
+	if err := DB.Model(&Ingests{}).Where("1 = 1").Update("deadline", past).Error; err != nil {
+		t.Fatalf("backdate ingests: %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const DEFAULT_QUIZ_SOURCE_NAME = "default"
+
+var ErrQuizSourceNotFound = errors.New("quiz source not found")
+
+// withSchemaDefaults fills in the field names of the tds-llm-analysis grader.
+func (s *QuizSource) withSchemaDefaults() *QuizSource {
+	if s.CorrectField == "" {
+		s.CorrectField = "correct"
+	}
+	if s.URLField == "" {
+		s.URLField = "url"
+	}
+	if s.ReasonField == "" {
+		s.ReasonField = "reason"
+	}
+	if s.DelayField == "" {
+		s.DelayField = "delay"
+	}
+	return s
+}
+
+// ParseResponse decodes a grader response according to the source's schema.
+// Only the correct field is required.
+func (s *QuizSource) ParseResponse(body []byte) (*InitialSubmissionResponse, error) {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+
+	correct, ok := lookupJSONPath(doc, s.CorrectField).(bool)
+	if !ok {
+		return nil, fmt.Errorf("response has no boolean %q field", s.CorrectField)
+	}
+
+	response := InitialSubmissionResponse{Correct: correct}
+
+	if url, ok := lookupJSONPath(doc, s.URLField).(string); ok {
+		response.URL = url
+	}
+	if reason, ok := lookupJSONPath(doc, s.ReasonField).(string); ok {
+		response.Reason = reason
+	}
+	if delay, ok := lookupJSONPath(doc, s.DelayField).(float64); ok {
+		seconds := int(delay)
+		response.Delay = &seconds
+	}
+
+	return &response, nil
+}
+
+func lookupJSONPath(doc any, path string) any {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := doc.(map[string]any)
+		if !ok {
+			return nil
+		}
+		doc = object[key]
+	}
+	return doc
+}
+
+// GetActiveQuizSource returns the source used for new ingests.
+func GetActiveQuizSource() (*QuizSource, error) {
+	var source QuizSource
+	err := DB.Where("active = ?", true).First(&source).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrQuizSourceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return source.withSchemaDefaults(), nil
+}
+
+// GetQuizSource looks a source up by name.
+func GetQuizSource(name string) (*QuizSource, error) {
+	var source QuizSource
+	err := DB.Where("name = ?", name).First(&source).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrQuizSourceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return source.withSchemaDefaults(), nil
+}
+
+// quizSourceForIngest returns the source an ingest was created under. An
+// ingest without one, e.g. from before sources or whose source was deleted,
+// gets the configured grader endpoints and the default schema.
+func quizSourceForIngest(ingestID uint) *QuizSource {
+	var ingest Ingests
+	if err := DB.First(&ingest, ingestID).Error; err == nil && ingest.QuizSourceID != nil {
+		var source QuizSource
+		if err := DB.First(&source, *ingest.QuizSourceID).Error; err == nil {
+			return source.withSchemaDefaults()
+		}
+	}
+
+	source := QuizSource{StartURL: AppConfig.GraderStartURL, SubmitURL: AppConfig.GraderSubmitURL}
+	return source.withSchemaDefaults()
+}
+
+func ListQuizSources() ([]QuizSource, error) {
+	var sources []QuizSource
+	err := DB.Order("name ASC").Find(&sources).Error
+	return sources, err
+}
+
+// SaveQuizSource creates or updates a source by name. The first source ever
+// saved becomes active.
+func SaveQuizSource(source *QuizSource) error {
+	if source.Name == "" {
+		return fmt.Errorf("quiz source name is required")
+	}
+	if !isHTTPURL(source.StartURL) || !isHTTPURL(source.SubmitURL) {
+		return fmt.Errorf("quiz source start and submit URLs must be http(s) URLs")
+	}
+	source.withSchemaDefaults()
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&QuizSource{}).Count(&count).Error; err != nil {
+			return err
+		}
+
+		var existing QuizSource
+		if err := tx.Where("name = ?", source.Name).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+
+		if existing.ID == 0 {
+			source.Active = count == 0
+			return tx.Create(source).Error
+		}
+
+		source.ID = existing.ID
+		source.Active = existing.Active
+		source.CreatedAt = existing.CreatedAt
+		return tx.Save(source).Error
+	})
+}
+
+// ActivateQuizSource makes the named source the one used for new ingests.
+func ActivateQuizSource(name string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var source QuizSource
+		err := tx.Where("name = ?", name).First(&source).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrQuizSourceNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&QuizSource{}).Where("id <> ?", source.ID).Update("active", false).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&source).Update("active", true).Error
+	})
+}
+
+// DeleteQuizSource removes an inactive source. Ingests created under it fall
+// back to the default response schema.
+func DeleteQuizSource(name string) error {
+	source, err := GetQuizSource(name)
+	if err != nil {
+		return err
+	}
+
+	if source.Active {
+		return fmt.Errorf("cannot delete the active quiz source; activate another one first")
+	}
+
+	return DB.Delete(&QuizSource{}, source.ID).Error
+}
+
+// EnsureDefaultQuizSource seeds a source from GRADER_START_URL and
+// GRADER_SUBMIT_URL when none exist yet.
+func EnsureDefaultQuizSource(cfg *Config) error {
+	var count int64
+	if err := DB.Model(&QuizSource{}).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	return SaveQuizSource(&QuizSource{
+		Name:      DEFAULT_QUIZ_SOURCE_NAME,
+		StartURL:  cfg.GraderStartURL,
+		SubmitURL: cfg.GraderSubmitURL,
+	})
+}
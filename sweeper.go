@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const SWEEP_INTERVAL = 5 * time.Second
+
+const (
+	FAILURE_REASON_NOT_ACCEPTED     = "not_accepted_before_deadline"
+	FAILURE_REASON_DEADLINE_EXPIRED = "deadline_expired"
+	FAILURE_REASON_ATTEMPT_EXPIRED  = "attempt_deadline_expired"
+)
+
+// SweepJob finalizes attempts, quiz sessions and ingests whose deadlines have
+// passed, so stale records stop showing up as pending or running. It also
+// drops expired idempotency keys and operator sessions.
+func SweepJob() {
+	now := time.Now()
+
+	if err := expireAttempts(now); err != nil {
+		log.Printf("Failed to expire quiz attempts: %v", err)
+	}
+
+	failedIngests, err := expireIngests(now)
+	if err != nil {
+		log.Printf("Failed to expire ingests: %v", err)
+	}
+
+	if err := expireSessions(failedIngests); err != nil {
+		log.Printf("Failed to expire quiz sessions: %v", err)
+	}
+
+	if err := purgeExpiredIdempotencyRecords(now); err != nil {
+		log.Printf("Failed to purge idempotency records: %v", err)
+	}
+
+	if err := purgeExpiredOperatorSessions(now); err != nil {
+		log.Printf("Failed to purge operator sessions: %v", err)
+	}
+
+	if err := purgeStaleFailedAttempts(now); err != nil {
+		log.Printf("Failed to purge failed attempt counters: %v", err)
+	}
+}
+
+// expireAttempts stamps ExpiredAt on unanswered attempts past their deadline.
+func expireAttempts(now time.Time) error {
+	var attempts []QuizAttempt
+	if err := DB.Where("(answer = '' OR answer IS NULL) AND expired_at IS NULL AND deadline > ? AND deadline <= ?", time.Time{}, now).
+		Find(&attempts).Error; err != nil {
+		return err
+	}
+
+	for _, attempt := range attempts {
+		result := DB.Model(&QuizAttempt{}).
+			Where("id = ? AND expired_at IS NULL AND (answer = '' OR answer IS NULL)", attempt.ID).
+			Update("expired_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		// Another replica may have expired it first, or an answer may have
+		// been recorded since it was read
+		if result.RowsAffected == 1 {
+			attempt.ExpiredAt = &now
+			Events.Publish(EVENT_ATTEMPT_EXPIRED, attempt)
+		}
+	}
+
+	return nil
+}
+
+// expireIngests fails every open ingest whose deadline has passed and
+// returns the IDs it finalized.
+func expireIngests(now time.Time) (map[uint]bool, error) {
+	var ingests []Ingests
+	if err := DB.Where("status IN (?, ?, ?) AND deadline <= ?",
+		IngestStatusPending, IngestStatusNotified, IngestStatusRunning, now).
+		Find(&ingests).Error; err != nil {
+		return nil, err
+	}
+
+	failed := map[uint]bool{}
+
+	for _, ingest := range ingests {
+		reason := FAILURE_REASON_DEADLINE_EXPIRED
+		if ingest.Status == IngestStatusPending {
+			reason = FAILURE_REASON_NOT_ACCEPTED
+		}
+
+		// A rejected transition means an answer landed mid-sweep and wins
+		err := transitionIngest(DB, &ingest, IngestStatusFailed, reason)
+		var transitionErr *TransitionError
+		if errors.As(err, &transitionErr) {
+			continue
+		}
+
+		if err != nil {
+			log.Printf("Failed to expire ingest ID %d: %v", ingest.ID, err)
+			continue
+		}
+
+		failed[ingest.ID] = true
+		log.Printf("Ingest ID %d expired (%s)", ingest.ID, reason)
+
+		err = SendNotification(Notification{
+			Title:   "Ingest expired",
+			Message: fmt.Sprintf("Ingest %d expired (%s)\n\nEmail: %s\nURL: %s", ingest.ID, reason, ingest.Email, ingest.URL),
+			Ingest:  &ingest,
+		})
+		if err != nil {
+			log.Printf("Failed to send expiry notification for ingest ID %d: %v", ingest.ID, err)
+		}
+	}
+
+	return failed, nil
+}
+
+// expireSessions fails sessions that are still waiting for an answer but
+// have no live attempt left. Sessions whose ingest was just failed are not
+// announced again.
+func expireSessions(failedIngests map[uint]bool) error {
+	livePending := DB.Model(&QuizAttempt{}).Select("session_id").
+		Where("(answer = '' OR answer IS NULL) AND expired_at IS NULL")
+	expired := DB.Model(&QuizAttempt{}).Select("session_id").
+		Where("expired_at IS NOT NULL")
+
+	var sessions []QuizSession
+	if err := DB.Where("status IN (?, ?) AND id IN (?) AND id NOT IN (?)",
+		QuizSessionStatusRunning, QuizSessionStatusWaiting, expired, livePending).
+		Find(&sessions).Error; err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		err := transitionSession(DB, &session, QuizSessionStatusFailed, FAILURE_REASON_ATTEMPT_EXPIRED)
+		var transitionErr *TransitionError
+		if errors.As(err, &transitionErr) {
+			continue
+		}
+
+		if err != nil {
+			log.Printf("Failed to expire quiz session ID %d: %v", session.ID, err)
+			continue
+		}
+
+		log.Printf("Quiz session ID %d expired", session.ID)
+
+		if failedIngests[session.IngestID] {
+			continue
+		}
+
+		err = SendNotification(Notification{
+			Title:   "Quiz session expired",
+			Message: fmt.Sprintf("Quiz session %d expired (%s)\n\nEmail: %s\nURL: %s", session.ID, FAILURE_REASON_ATTEMPT_EXPIRED, session.Email, session.CurrentURL),
+		})
+		if err != nil {
+			log.Printf("Failed to send expiry notification for quiz session ID %d: %v", session.ID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A correctly signed token that was already consumed, e.g. by a second tap
+var ErrAcceptTokenUsed = errors.New("token already used")
+
+// IssueAcceptToken creates a signed, single-use token that accepts the given
+// ingest until expiresAt. ACCEPT_TOKEN_SECRET must be set.
+func IssueAcceptToken(ingestID uint, expiresAt time.Time) (string, error) {
+	key := AppConfig.AcceptTokenSecret
+	if key == "" {
+		return "", fmt.Errorf("ACCEPT_TOKEN_SECRET is not configured")
+	}
+
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return "", fmt.Errorf("failed to generate token nonce: %v", err)
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+
+	record := AcceptToken{
+		IngestID:  ingestID,
+		Nonce:     nonce,
+		ExpiresAt: expiresAt,
+	}
+
+	if err := DB.Create(&record).Error; err != nil {
+		return "", fmt.Errorf("failed to store accept token: %v", err)
+	}
+
+	payload := fmt.Sprintf("%d.%d.%s", ingestID, expiresAt.Unix(), nonce)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(signAcceptToken(key, payload)), nil
+}
+
+// ConsumeAcceptToken verifies a token and marks it used, returning the
+// ingest it was issued for. A token can only be consumed once.
+func ConsumeAcceptToken(token string) (uint, error) {
+	ingestID, nonce, err := parseAcceptToken(token)
+	if err != nil {
+		return 0, err
+	}
+
+	// Claim the token in a single statement so concurrent taps cannot both succeed
+	result := DB.Model(&AcceptToken{}).
+		Where("nonce = ? AND ingest_id = ? AND used_at IS NULL", nonce, ingestID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return 0, ErrAcceptTokenUsed
+	}
+
+	return ingestID, nil
+}
+
+// ReleaseAcceptToken makes a consumed token usable again, for when the
+// accept it was consumed for failed before taking effect.
+func ReleaseAcceptToken(token string) error {
+	ingestID, nonce, err := parseAcceptToken(token)
+	if err != nil {
+		return err
+	}
+
+	return DB.Model(&AcceptToken{}).
+		Where("nonce = ? AND ingest_id = ?", nonce, ingestID).
+		Update("used_at", nil).Error
+}
+
+// parseAcceptToken checks a token's signature and expiry and returns the
+// ingest and nonce it carries.
+func parseAcceptToken(token string) (uint, string, error) {
+	key := AppConfig.AcceptTokenSecret
+	if key == "" {
+		return 0, "", fmt.Errorf("ACCEPT_TOKEN_SECRET is not configured")
+	}
+
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, "", fmt.Errorf("malformed token")
+	}
+
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed token")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed token")
+	}
+
+	payload := string(payloadBytes)
+	if !hmac.Equal(sig, signAcceptToken(key, payload)) {
+		return 0, "", fmt.Errorf("invalid token signature")
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return 0, "", fmt.Errorf("malformed token")
+	}
+
+	ingestID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed token")
+	}
+
+	expiresUnix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed token")
+	}
+
+	if time.Now().After(time.Unix(expiresUnix, 0)) {
+		return 0, "", fmt.Errorf("token expired")
+	}
+
+	return uint(ingestID), parts[2], nil
+}
+
+func signAcceptToken(key, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -4,8 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
 )
 
 type InitialSubmissionRequest struct {
@@ -22,6 +22,8 @@ type InitialSubmissionResponse struct {
 	Delay   *int   `json:"delay"`
 }
 
+// generateBeepPayload returns one 20ms G.711 A-law frame (160 samples) of a
+// 400Hz square wave, used as the escalation call tone.
 func generateBeepPayload() []byte {
 	data := make([]byte, 160)
 
@@ -36,11 +38,13 @@ func generateBeepPayload() []byte {
 	return data
 }
 
-func SubmitInitialRequest(email, secret, answer string) (*InitialSubmissionResponse, error) {
+// SubmitInitialRequest posts the opening answer to a quiz source and parses
+// the reply using the source's response schema.
+func SubmitInitialRequest(source *QuizSource, email, secret, answer string) (*InitialSubmissionResponse, error) {
 	req := InitialSubmissionRequest{
 		Email:  email,
 		Secret: secret,
-		URL:    "https://tds-llm-analysis.s-anand.net/project2",
+		URL:    source.StartURL,
 		Answer: answer,
 	}
 
@@ -50,7 +54,7 @@ func SubmitInitialRequest(email, secret, answer string) (*InitialSubmissionRespo
 	}
 
 	resp, err := http.Post(
-		"https://tds-llm-analysis.s-anand.net/submit",
+		source.SubmitURL,
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -63,28 +67,31 @@ func SubmitInitialRequest(email, secret, answer string) (*InitialSubmissionRespo
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	var response InitialSubmissionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("error decoding response: %v", err)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
 	}
 
-	return &response, nil
+	return source.ParseResponse(body)
 }
 
-// MakeInitialSubmission makes the initial submission using environment variables
+// MakeInitialSubmission makes the initial submission against the active quiz
+// source using the configured credentials
 // This can be called to initiate the first request programmatically
 func MakeInitialSubmission(answer string) (*InitialSubmissionResponse, error) {
-	email := os.Getenv("SUBMISSION_EMAIL")
-	secret := os.Getenv("SECRET")
+	email := AppConfig.SubmissionEmail
+	secret := AppConfig.Secret
 
 	if email == "" {
-		return nil, fmt.Errorf("SUBMISSION_EMAIL environment variable not set")
+		return nil, fmt.Errorf("SUBMISSION_EMAIL is not configured")
 	}
-	if secret == "" {
-		return nil, fmt.Errorf("SECRET environment variable not set")
+
+	source, err := GetActiveQuizSource()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load active quiz source: %v", err)
 	}
 
-	return ProcessInitialSubmissionFlow(email, secret, answer)
+	return ProcessInitialSubmissionFlow(source, email, secret, answer, AuditContext{Actor: "system"})
 }
 
 // ExtractURLAndID extracts the next URL and ID from the initial submission response
@@ -101,9 +108,12 @@ func ExtractURLAndID(response *InitialSubmissionResponse) (nextURL string, id st
 }
 
 // ProcessInitialSubmissionFlow handles the complete flow from initial submission to creating ingest and quiz session
-func ProcessInitialSubmissionFlow(email, secret, answer string) (*InitialSubmissionResponse, error) {
+func ProcessInitialSubmissionFlow(source *QuizSource, email, secret, answer string, audit AuditContext) (_ *InitialSubmissionResponse, err error) {
+	var targets AuditTargets
+	defer func() { recordAuditEvent(audit, AUDIT_ACTION_INITIAL_SUBMISSION, targets, err) }()
+
 	// Make the initial submission
-	response, err := SubmitInitialRequest(email, secret, answer)
+	response, err := SubmitInitialRequest(source, email, secret, answer)
 	if err != nil {
 		return nil, fmt.Errorf("initial submission failed: %v", err)
 	}
@@ -118,19 +128,19 @@ func ProcessInitialSubmissionFlow(email, secret, answer string) (*InitialSubmiss
 		}
 
 		// Create ingest record first
-		err = Ingest(taskReq)
+		ingest, err := Ingest(taskReq, source)
 		if err != nil {
 			return response, fmt.Errorf("failed to create ingest: %v", err)
 		}
+		targets.IngestID = &ingest.ID
 
-		// Get the created ingest to link with quiz session
-		var ingest Ingests
-		if err := DB.Where("email = ? AND url = ?", email, response.URL).First(&ingest).Error; err != nil {
-			return response, fmt.Errorf("failed to find created ingest: %v", err)
+		// The operator who made the submission started this run
+		if err := DB.Model(ingest).Update("accepted_by", audit.Actor).Error; err != nil {
+			return response, fmt.Errorf("failed to record operator: %v", err)
 		}
 
 		// Start quiz session with ingest link
-		err = StartQuizSessionWithIngest(taskReq, ingest.ID)
+		err = StartQuizSession(ingest.ID)
 		if err != nil {
 			return response, fmt.Errorf("failed to start quiz session: %v", err)
 		}